@@ -0,0 +1,117 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import (
+	"sync"
+	"time"
+)
+
+// A breaker is the circuit breaker of one handler.  It trips after a
+// number of consecutive failures and then lets no invocation through
+// until the cooldown has passed, when a single probe may run: a
+// successful probe closes the breaker and a failed one trips it again.
+type breaker struct {
+	mu       sync.Mutex
+	failures int
+	cooldown time.Duration
+	streak   int
+	until    time.Time
+	probing  bool
+	clock    Clock
+}
+
+// WithCircuitBreaker gives every handler registered with the bus a
+// circuit breaker that trips after the given number of consecutive
+// failures.  A tripped handler is not invoked for cooldown; its
+// skipped invocations count neither as successes nor failures, and a
+// payload whose only subscribers are tripped handlers goes to the dead
+// letter handler.  Once the cooldown has passed, the next payload
+// probes the handler, which is closed again if it succeeds.  The
+// number of tripped handlers of each type is in the Tripped statistic.
+func WithCircuitBreaker(failures int, cooldown time.Duration) Option {
+	return func(b *Bus) {
+		b.failures = failures
+		b.cooldown = cooldown
+	}
+}
+
+// newBreaker returns the breaker for a new handler, or nil if the bus
+// has no circuit breakers.
+func (b *Bus) newBreaker() *breaker {
+	if b.failures <= 0 {
+		return nil
+	}
+	return &breaker{failures: b.failures, cooldown: b.cooldown, clock: b.clock}
+}
+
+// allow reports whether the handler may be invoked, claiming the probe
+// if the cooldown has passed.  A nil breaker allows everything.
+func (br *breaker) allow() bool {
+	if br == nil {
+		return true
+	}
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	if br.streak < br.failures {
+		return true
+	}
+	if br.probing || br.clock.Now().Before(br.until) {
+		return false
+	}
+	br.probing = true
+	return true
+}
+
+// record records the outcome of an invocation and reports whether it
+// tripped the breaker.
+func (br *breaker) record(err error) bool {
+	if br == nil {
+		return false
+	}
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	br.probing = false
+	if err == nil {
+		br.streak = 0
+		return false
+	}
+	br.streak++
+	if br.streak < br.failures {
+		return false
+	}
+	br.until = br.clock.Now().Add(br.cooldown)
+	return true
+}
+
+// abandon gives up a probe claimed by allow that did not run.
+func (br *breaker) abandon() {
+	if br == nil {
+		return
+	}
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	br.probing = false
+}
+
+// tripped reports whether the breaker currently refuses invocations.
+func (br *breaker) tripped() bool {
+	if br == nil {
+		return false
+	}
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	return br.streak >= br.failures && (br.probing || br.clock.Now().Before(br.until))
+}
+
+// allTripped reports whether every handler entry is tripped.
+func allTripped(entries []*handlerEntry) bool {
+	for _, e := range entries {
+		if !e.breaker.tripped() {
+			return false
+		}
+	}
+	return true
+}
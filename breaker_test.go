@@ -0,0 +1,53 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pajato/event"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := New(WithCircuitBreaker(2, 30*time.Millisecond))
+	defer b.Close()
+	name := "testEventBreaker"
+	var calls, failing, dead int32 = 0, 1, 0
+	b.AddHandlers(name, func(p Payload) error {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			return errors.New("down")
+		}
+		return nil
+	})
+	b.SetDeadLetterHandler(func(p Payload) { atomic.AddInt32(&dead, 1) })
+	for i := 0; i < 4; i++ {
+		b.PostAndWait(event.New(name))
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("The handler should be invoked until it trips, twice, but was invoked %v times.", n)
+	}
+	if n := atomic.LoadInt32(&dead); n != 2 {
+		t.Errorf("The 2 payloads posted while tripped should be dead letters, but %v were.", n)
+	}
+	if n := b.Stats().Types[name].Tripped; n != 1 {
+		t.Errorf("One handler should be tripped, but the count is: %v.", n)
+	}
+	time.Sleep(40 * time.Millisecond)
+	atomic.StoreInt32(&failing, 0)
+	if err := b.PostAndWait(event.New(name)); err != nil {
+		t.Errorf("The probe failed with message: %v.\n", err)
+	}
+	b.PostAndWait(event.New(name))
+	if n := atomic.LoadInt32(&calls); n != 4 {
+		t.Errorf("The handler should be probed and closed after the cooldown, but was invoked %v times.", n)
+	}
+	if n := b.Stats().Types[name].Tripped; n != 0 {
+		t.Errorf("No handler should be tripped after a successful probe, but the count is: %v.", n)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+// A Builder describes a handler registration step by step, as in
+//
+//	b.On("order.placed").Filter(large).Priority(5).Group("api", 4).Handle(h)
+//
+// combining the options of AddFilteredHandler, AddHandlersWithPriority,
+// AddOnceHandlers and AddHandlersInGroup in one registration.  A
+// Builder is a value and each of its methods returns a modified copy,
+// so a partly configured Builder can be kept and reused to register
+// several handlers alike.
+type Builder struct {
+	b        *Bus
+	typ      string
+	filter   func(p Payload) bool
+	priority int
+	once     bool
+	group    string
+	limit    int
+}
+
+// On starts the registration of handlers for a given payload type.
+func (b *Bus) On(typ string) Builder {
+	return Builder{b: b, typ: typ}
+}
+
+// Filter makes the handlers skip the payloads filter rejects, like
+// AddFilteredHandler.
+func (sb Builder) Filter(filter func(p Payload) bool) Builder {
+	sb.filter = filter
+	return sb
+}
+
+// Priority sets the priority of the handlers, like
+// AddHandlersWithPriority.
+func (sb Builder) Priority(priority int) Builder {
+	sb.priority = priority
+	return sb
+}
+
+// Once makes the handlers run for the first matching payload only,
+// like AddOnceHandlers.
+func (sb Builder) Once() Builder {
+	sb.once = true
+	return sb
+}
+
+// Group makes the handlers members of a handler group, like
+// AddHandlersInGroup.
+func (sb Builder) Group(group string, limit int) Builder {
+	sb.group = group
+	sb.limit = limit
+	return sb
+}
+
+// Handle registers one or more handlers as described and returns the
+// Subscription that removes them again.
+func (sb Builder) Handle(fns ...Handler) (Subscription, error) {
+	entries := make([]*handlerEntry, len(fns))
+	for i, fn := range fns {
+		entries[i] = &handlerEntry{fn: fn}
+	}
+	return sb.register(entries)
+}
+
+// HandleContext registers one or more context aware handlers as
+// described, like AddContextHandlers.
+func (sb Builder) HandleContext(fns ...ContextHandler) (Subscription, error) {
+	entries := make([]*handlerEntry, len(fns))
+	for i, fn := range fns {
+		entries[i] = &handlerEntry{cfn: fn}
+	}
+	return sb.register(entries)
+}
+
+func (sb Builder) register(entries []*handlerEntry) (Subscription, error) {
+	if len(entries) == 0 {
+		message := "Argument error: at least one handler must be registered."
+		return Subscription{}, &busError{sb.b.clock.Now(), message, CodeNoHandlers, nil}
+	}
+	var sem chan struct{}
+	if sb.group != "" {
+		var err error
+		if sem, err = sb.b.group(sb.group, sb.limit); err != nil {
+			return Subscription{}, err
+		}
+	}
+	for _, e := range entries {
+		e.filter = sb.filter
+		e.priority = sb.priority
+		e.once = sb.once
+		e.sem = sem
+	}
+	return sb.b.add(sb.typ, entries)
+}
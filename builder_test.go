@@ -0,0 +1,51 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/pajato/event"
+)
+
+func TestBuilder(t *testing.T) {
+	b := New()
+	defer b.Close()
+	name := "testEventBuilder"
+	var mu sync.Mutex
+	var got []string
+	record := func(s string) Handler {
+		return func(p Payload) error {
+			mu.Lock()
+			got = append(got, s)
+			mu.Unlock()
+			return nil
+		}
+	}
+	large := b.On(name).Filter(func(p Payload) bool { return p.Data()["amount"].(int) > 100 })
+	if _, err := large.Priority(5).Handle(record("late")); err != nil {
+		t.Fatalf("Registering failed with message: %v.\n", err)
+	}
+	if _, err := large.Once().Group("api", 2).Handle(record("once")); err != nil {
+		t.Fatalf("Registering failed with message: %v.\n", err)
+	}
+	for _, amount := range []int{50, 500, 700} {
+		e := event.New(name)
+		e.Data()["amount"] = amount
+		b.PostAndWait(e)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 3 || got[0] != "once" || got[1] != "late" || got[2] != "late" {
+		t.Errorf("The handlers should run filtered, by priority and once, but ran: %v.", got)
+	}
+	if _, err := b.On(name).Group("api", 3).Handle(h1); err == nil {
+		t.Error("Joining a group with a different limit did not fail as expected.")
+	}
+	if _, err := b.On(name).Handle(); err == nil {
+		t.Error("Registering no handlers did not fail as expected.")
+	}
+}
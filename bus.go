@@ -18,9 +18,19 @@
 package bus
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unsafe"
 )
 
 // A Payload type will provide a type and some, possibly empty, data.
@@ -31,97 +41,1601 @@ type Payload interface {
 	Data() map[string]interface{}
 }
 
+// A MetaPayload is a Payload that keeps its headers, the envelope of
+// routing and tracing metadata such as a correlation or trace id,
+// apart from its data, the body.  The bus stores and reads the
+// metadata it reserves keys for in the headers of a MetaPayload whose
+// Meta() is not nil, and in the data of any other payload.  The
+// payloads made by NewPayload and NewValuePayload are MetaPayloads.
+type MetaPayload interface {
+	Payload
+	Meta() map[string]interface{}
+}
+
 // A Handler instance will be called by the bus when an event of the
 // type registered for it is posted to the bus.  Handlers are
 // registered via the Subscribe method.
 type Handler func(p Payload) error
 
-// The flag type acts as a base type for Bus constants.
-type flag int
+// A ContextHandler is a Handler that also receives the context the
+// payload was posted with.  ContextHandlers are registered via the
+// AddContextHandlers method.
+type ContextHandler func(ctx context.Context, p Payload) error
+
+// A ModeHandler is a Handler that also receives the mode the payload
+// is delivered in, so that it can, say, put off heavy work when a
+// poster waits for it.  ModeHandlers are registered via the
+// AddModeHandlers method.
+type ModeHandler func(p Payload, mode Mode) error
+
+// A Middleware wraps a Handler with behaviour shared by every handler,
+// such as timing, logging or authorization.  It may short-circuit the
+// delivery of a payload to the handler by returning an error without
+// calling next.  Middleware is installed via the Use method.
+type Middleware func(next Handler) Handler
+
+// A Subscription identifies the handlers registered by a single call
+// to AddHandlers.  It is opaque and is only useful as an argument to
+// Unsubscribe.
+type Subscription struct {
+	id uint64
+}
+
+// A handlerEntry pairs a registered handler, a Handler, ContextHandler
+// or ModeHandler, with the id of the subscription that registered it.
+// A handler with a filter is skipped for payloads the filter rejects,
+// and a once handler is claimed by setting fired, atomically, before
+// it is invoked.
+type handlerEntry struct {
+	id       uint64
+	priority int
+	fn       Handler
+	cfn      ContextHandler
+	mfn      ModeHandler
+	filter   func(p Payload) bool
+	once     bool
+	fired    int32
+	sem      chan struct{}
+	ready    chan struct{}
+	breaker  *breaker
+	errs     *errChan
+}
+
+// key identifies the entry's handler function so that a handler
+// matched through more than one registered type runs only once.  A
+// func value refers to its function (and any captured variables)
+// through a single pointer, which unlike the code pointer returned by
+// reflect tells apart closures created from the same function literal.
+func (e *handlerEntry) key() unsafe.Pointer {
+	switch {
+	case e.cfn != nil:
+		return *(*unsafe.Pointer)(unsafe.Pointer(&e.cfn))
+	case e.mfn != nil:
+		return *(*unsafe.Pointer)(unsafe.Pointer(&e.mfn))
+	}
+	return *(*unsafe.Pointer)(unsafe.Pointer(&e.fn))
+}
+
+// handler returns the entry's handler bound to the given context and
+// delivery mode.
+func (e *handlerEntry) handler(ctx context.Context, mode Mode) Handler {
+	switch {
+	case e.cfn != nil:
+		return func(p Payload) error { return e.cfn(ctx, p) }
+	case e.mfn != nil:
+		return func(p Payload) error { return e.mfn(p, mode) }
+	}
+	return e.fn
+}
+
+// An OverflowPolicy tells the bus what to do with a payload that a
+// subscriber channel cannot accept in time.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for the channel to accept the payload, for
+	// at most the SendTimeout if one is set, then drops it.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDrop drops the payload.
+	OverflowDrop
+
+	// OverflowError drops the payload and reports an error for its
+	// delivery.
+	OverflowError
+)
+
+var policyNames = [...]string{
+	OverflowBlock: "block",
+	OverflowDrop:  "drop",
+	OverflowError: "error",
+}
+
+// String returns the name of the policy.
+func (p OverflowPolicy) String() string {
+	if p < 0 || int(p) >= len(policyNames) {
+		return fmt.Sprintf("policy %d", int(p))
+	}
+	return policyNames[p]
+}
+
+// ChannelOptions control how payloads are sent to a subscriber
+// channel.  SendTimeout bounds how long a send may wait for the channel
+// to accept a payload; zero means OverflowBlock waits indefinitely,
+// or for the grace period set by WithChannelGracePeriod, and the other
+// policies do not wait at all.  Dropped payloads are always
+// logged.  The zero value blocks like AddChannel.
+type ChannelOptions struct {
+	SendTimeout time.Duration
+	Overflow    OverflowPolicy
+}
+
+// A channelEntry pairs a subscriber channel with its options.  Sends
+// hold the read lock of mu and give up once done is closed, so that
+// removing the channel can wait for them to finish.
+type channelEntry struct {
+	c       chan Payload
+	opts    ChannelOptions
+	mu      sync.RWMutex
+	done    chan struct{}
+	ready   chan struct{}
+	removed bool
+}
+
+func newChannelEntry(c chan Payload, opts ChannelOptions) *channelEntry {
+	return &channelEntry{c: c, opts: opts, done: make(chan struct{})}
+}
+
+// remove stops the delivery of payloads to the channel, waiting for a
+// send in progress to finish or give up.  It must be called only once,
+// by whoever took the entry out of the subscriber lists.
+func (ce *channelEntry) remove() {
+	close(ce.done)
+	ce.mu.Lock()
+	ce.removed = true
+	ce.mu.Unlock()
+}
+
+// A Mode tells how a payload is delivered.  A payload delivered
+// Synchronously is delivered before its post returns, with the errors
+// of its handlers returned to the poster, while one delivered
+// Asynchronously is delivered after its post returns, with handler
+// errors going to the error handler.
+type Mode int
+
+// The delivery modes.
+const (
+	Synchronous  Mode = 1 << iota
+	Asynchronous Mode = 1 << iota
+)
+
+// String returns "synchronously" or "asynchronously".
+func (m Mode) String() string {
+	if (m & Asynchronous) == Asynchronous {
+		return "asynchronously"
+	}
+	return "synchronously"
+}
+
+// A rider carries a payload, a delivery mode and the context and time
+// the payload was posted with.  A synchronous rider also carries a done
+// channel on which the outcome of the delivery is sent once delivery
+// completes and, when posted by Deliver, a place for the result of
+// each handler.  A rider posted by PostBatch carries no payload of its
+// own but the asynchronous riders of the whole batch, and one posted by
+// Flush carries nothing but a channel to close once it is picked up.
+type rider struct {
+	payload Payload
+	mode    Mode
+	ctx     context.Context
+	done    chan error
+	posted  time.Time
+	results *[]DeliveryResult
+	batch   []rider
+	flushed chan struct{}
+	id      uint64
+	burst   bool
+}
+
+// members returns the riders of a batch, none for a flush, or else the
+// rider itself.
+func (r rider) members() []rider {
+	if r.batch != nil || r.flushed != nil {
+		return r.batch
+	}
+	return []rider{r}
+}
+
+// A MultiError collects the errors returned by the handlers for a
+// single payload, in handler registration order.
+type MultiError []error
+
+// Error joins the messages of the collected errors.
+func (m MultiError) Error() string {
+	messages := make([]string, len(m))
+	for i, err := range m {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Unwrap exposes the collected errors to errors.Is and errors.As.
+func (m MultiError) Unwrap() []error {
+	return m
+}
+
+// A Bus instance will communicate Payload objects to other goroutines
+// using a channel and/or a list of handlers.  A Bus is created by New
+// and used through the returned pointer; it must not be copied.  The
+// mutex guards the subchans and handlers maps, which are read by the
+// delivering goroutines and written by any goroutine registering
+// subscribers.
+type Bus struct {
+	// The atomically updated counters come first to keep them 64-bit
+	// aligned on 32-bit platforms.
+	nextID      uint64
+	sequence    uint64
+	inflight    int64
+	paused      int32
+	pubchan     chan rider
+	work        chan rider
+	quit        chan struct{}
+	stopped     chan struct{}
+	pausing     chan bool
+	pending     pendingCount
+	once        sync.Once
+	gate        gate
+	sched       schedule
+	mu          sync.RWMutex
+	subchans    map[string][]*channelEntry
+	handlers    map[string][]*handlerEntry
+	modes       map[string]Mode
+	groups      map[string]chan struct{}
+	replays     map[string]*replayBuffer
+	cfg         config
+	stats       *counters
+	logger      Logger
+	level       LogLevel
+	buffer      int
+	workers     int
+	timeout     time.Duration
+	ordered     bool
+	nonblocking bool
+	validator   func(p Payload) error
+	enricher    func(p Payload) Payload
+	meta        bool
+	dedup       *dedup
+	idempotency *idempotency
+	grace       time.Duration
+	parent      *Bus
+	lineage     sync.Mutex
+	forwarded   map[string]bool
+	known       map[string]bool
+	strict      bool
+	failures    int
+	cooldown    time.Duration
+	limits      map[string]*limiter
+	router      func(p Payload) string
+	commands    map[string]Handler
+	order       DeliveryOrder
+	attempts    int
+	backoff     func(attempt int) time.Duration
+	inline      bool
+	stopOnError bool
+	deliveries  map[uint64]context.CancelFunc
+	clock       Clock
+	sequenced   bool
+	stamping    sync.Mutex
+	panics      PanicPolicy
+	sampler     *sampler
+	parallel    bool
+	slow        time.Duration
+	coalescers  map[string]*coalescer
+}
+
+// The gate type lets Close wait for posts in progress to finish before
+// it marks the bus closed.
+type gate struct {
+	sync.RWMutex
+	closed   bool
+	draining bool
+}
+
+// A pendingCount counts the deliveries still pending.  Unlike a
+// sync.WaitGroup it may be added to from zero while it is waited for,
+// as posts are made while Wait or Drain block: each waiter waits for
+// the channel of the current idle period, closed when the count drops
+// back to zero.
+type pendingCount struct {
+	mu   sync.Mutex
+	n    int
+	idle chan struct{}
+}
+
+// Add adds delta, which may be negative, to the count.
+func (c *pendingCount) Add(delta int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	was := c.n
+	c.n += delta
+	switch {
+	case c.n < 0:
+		panic("bus: negative pending count")
+	case was == 0 && c.n > 0:
+		c.idle = make(chan struct{})
+	case was > 0 && c.n == 0:
+		close(c.idle)
+	}
+}
+
+// Done decrements the count by one.
+func (c *pendingCount) Done() {
+	c.Add(-1)
+}
+
+// Wait blocks until the count is zero.
+func (c *pendingCount) Wait() {
+	<-c.zero()
+}
+
+// zero returns a channel closed once the count is zero.
+func (c *pendingCount) zero() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.n == 0 {
+		idle := make(chan struct{})
+		close(idle)
+		return idle
+	}
+	return c.idle
+}
+
+// The config type holds the settings that can be changed after New.
+// It is guarded by the bus mutex.
+type config struct {
+	errorHandler func(p Payload, err error)
+	deadLetter   func(p Payload)
+	middleware   []Middleware
+}
+
+// A Logger receives the messages the bus logs.  A *log.Logger is a
+// Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// A LogLevel selects how much the bus logs.  Each level includes the
+// levels before it: LogError logs failures, such as failing handlers
+// and dropped payloads, LogInfo adds lifecycle messages and LogDebug
+// adds a line for every post and every handler invocation.
+type LogLevel int
+
+// The log levels.  The default is LogError.
+const (
+	LogOff LogLevel = iota
+	LogError
+	LogInfo
+	LogDebug
+)
+
+// logs reports whether the bus logs messages of the given level.
+func (b *Bus) logs(level LogLevel) bool {
+	return b.level >= level
+}
+
+// Log a message using the logger the bus was created with, unless the
+// log level is LogOff.
+func (b *Bus) Log(message string) {
+	if b.logs(LogError) {
+		b.logger.Printf("%s", message)
+	}
+}
+
+// Post will asynchonously notify all subscribers that a payload of a
+// certain type is available.  A nil payload, a payload with an empty
+// type and a payload rejected by the validator the bus was created
+// with are not posted; an error is returned instead.  A bus created
+// with an enricher posts the payload the enricher returns.
+func (b *Bus) Post(p Payload) error {
+	p, err := b.prepare(p)
+	if err != nil {
+		return err
+	}
+	return b.postPrepared(p)
+}
+
+// postPrepared posts a payload already readied by prepare like Post.
+func (b *Bus) postPrepared(p Payload) error {
+	if b.duplicate(p) || b.coalesce(p) {
+		return nil
+	}
+	if b.logs(LogDebug) {
+		b.logger.Printf("Posting payload of type: %v.\n", p.Type())
+	}
+	return b.revoke(p, b.post(context.Background(), p, b.modeOf(p.Type(), Asynchronous)))
+}
+
+// PostAsync will post a payload asynchronously like Post and return a
+// channel that receives the outcome of its delivery, nil or the error
+// Post would give the error handler, once delivery completes, and is
+// then closed.  An error posting the payload, such as ErrBusClosed, is
+// received at once, and a payload dropped as a duplicate closes the
+// channel without a value.  The channel is closed in any case, so a
+// caller may wait on it or ignore it.  The payload is delivered
+// asynchronously whatever the mode of its type.
+func (b *Bus) PostAsync(p Payload) <-chan error {
+	p, err := b.prepare(p)
+	if err != nil || b.duplicate(p) {
+		return settled(err)
+	}
+	if b.logs(LogDebug) {
+		b.logger.Printf("Posting payload of type: %v.\n", p.Type())
+	}
+	r := rider{payload: p, mode: Asynchronous, ctx: context.Background(), done: make(chan error, 1)}
+	if err := b.send(r); err != nil {
+		return settled(b.revoke(p, err))
+	}
+	return r.done
+}
+
+// settled returns a closed channel holding err unless it is nil.
+func settled(err error) <-chan error {
+	c := make(chan error, 1)
+	if err != nil {
+		c <- err
+	}
+	close(c)
+	return c
+}
+
+// TryPost will post a payload asynchronously like Post if, and only if,
+// that can be done without waiting, and report whether it did.  It
+// returns false at once when the posting channel is full, so that a
+// producer can shed load or retry later, as well as when the payload
+// is invalid or the bus is closed.  With the default unbuffered
+// posting channel a payload is only accepted while the bus goroutine
+// is idle; see WithPubChanBuffer.  Stats reports the queue depth.
+func (b *Bus) TryPost(p Payload) (accepted bool) {
+	p, err := b.prepare(p)
+	if err != nil {
+		if b.logs(LogInfo) {
+			b.logger.Printf("Post rejected: %v.\n", err)
+		}
+		return false
+	}
+	if b.duplicate(p) {
+		return true
+	}
+	r := rider{payload: p, mode: Asynchronous, ctx: context.Background()}
+	if err := b.enqueue(r, false); err != nil {
+		b.revoke(p, err)
+		if b.logs(LogInfo) {
+			b.logger.Printf("Post rejected: %v.\n", err)
+		}
+		return false
+	}
+	return true
+}
+
+// PostAndWait synchronously notifies all subscribers.  It returns only
+// after every handler has run and every subscriber channel has
+// accepted the payload.  If any handlers fail, the returned error is a
+// MultiError holding their errors in handler registration order.
+// Because sends to subscriber channels block, PostAndWait will not
+// return while a subscriber channel has no reader, so subscribers must
+// keep reading (or use a buffered channel) for as long as they are
+// registered.  The handler of a synchronous delivery may itself call
+// PostAndWait, or any other post, to trigger follow-up payloads.  The
+// handler of an asynchronous delivery holds a worker while it runs,
+// and the bus goroutine accepts no post while it waits for a free
+// worker, so should every worker be in a handler that posts, the bus
+// deadlocks.  An asynchronous handler that posts should leave the post
+// to a goroutine of its own, as with go b.Post(p), unless the bus has
+// more workers than handlers posting at once.
+func (b *Bus) PostAndWait(p Payload) error {
+	return b.PostWithContext(context.Background(), p)
+}
+
+// PostWithContext synchronously notifies all subscribers like
+// PostAndWait, passing ctx to every ContextHandler.  If ctx is
+// cancelled before delivery completes, the remaining handlers and
+// subscriber channels are skipped and ctx.Err() is returned without
+// waiting for a handler that is still running.
+func (b *Bus) PostWithContext(ctx context.Context, p Payload) error {
+	p, err := b.prepare(p)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil || b.duplicate(p) {
+		return err
+	}
+	if b.logs(LogDebug) {
+		b.logger.Printf("Posting payload of type: %v.\n", p.Type())
+	}
+	return b.revoke(p, b.post(ctx, p, b.modeOf(p.Type(), Synchronous)))
+}
+
+// PostAndWaitTimeout synchronously notifies all subscribers like
+// PostAndWait, but returns an ErrTimeout error if delivery has not
+// completed within d.  The handlers and subscriber channels are not
+// interrupted: delivery continues in the background and its outcome
+// is discarded.  The timeout error is returned as is, whereas
+// the errors of failing handlers, including those that exceed the
+// handler timeout, always come inside a MultiError, so errors.As
+// tells the two apart.
+func (b *Bus) PostAndWaitTimeout(p Payload, d time.Duration) error {
+	p, err := b.prepare(p)
+	if err != nil || b.duplicate(p) {
+		return err
+	}
+	if b.logs(LogDebug) {
+		b.logger.Printf("Posting payload of type: %v.\n", p.Type())
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- b.revoke(p, b.post(context.Background(), p, b.modeOf(p.Type(), Synchronous)))
+	}()
+	t := b.clock.NewTimer(d)
+	defer t.Stop()
+	select {
+	case err := <-result:
+		return err
+	case <-t.C():
+		message := fmt.Sprintf("Timeout error: delivery of payload with type: %v did not complete within %v.", p.Type(), d)
+		return &busError{b.clock.Now(), message, CodeTimeout, nil}
+	}
+}
+
+// post sends a prepared payload to the bus goroutine for delivery in
+// the given mode, waiting for a synchronous delivery to complete.
+func (b *Bus) post(ctx context.Context, p Payload, mode Mode) error {
+	if mode == Asynchronous {
+		return b.send(rider{payload: p, mode: Asynchronous, ctx: ctx})
+	}
+	r := rider{payload: p, mode: Synchronous, ctx: ctx, done: make(chan error, 1)}
+	if b.inline {
+		return b.deliverInline(r)
+	}
+	if err := b.send(r); err != nil {
+		return err
+	}
+	select {
+	case err := <-r.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SetTypeMode will make every payload of a given type, or of the types
+// matching a given wildcard, be delivered in the given mode, overriding
+// the mode of Post, PostAndWait and PostWithContext.  So with
+// SetTypeMode("audit.*", Synchronous) a Post of an "audit.login"
+// payload waits for its delivery and returns the handler errors, and
+// with Asynchronous a PostAndWait returns once the payload is queued.
+// The mode of the exact type wins over those of matching wildcards,
+// the most specific wildcard first.  A mode of zero removes the
+// override.  TryPost, PostAsync, PostBatch and Deliver keep their own
+// modes.
+func (b *Bus) SetTypeMode(typ string, mode Mode) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if mode == 0 {
+		delete(b.modes, typ)
+		return
+	}
+	b.modes[typ] = mode
+}
+
+// modeOf returns the mode set for a payload type or the most specific
+// wildcard matching it, or else the given mode.
+func (b *Bus) modeOf(typ string, mode Mode) Mode {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if m, ok := b.modes[typ]; ok {
+		return m
+	}
+	for i := strings.LastIndex(typ, "."); i >= 0; i = strings.LastIndex(typ[:i], ".") {
+		if m, ok := b.modes[typ[:i+1]+"*"]; ok {
+			return m
+		}
+	}
+	return mode
+}
+
+// PostBatch will asynchronously post every payload given in one step,
+// costing a single send to the bus goroutine.  The payloads of a batch
+// are handed out for delivery one after the other in the given order,
+// with no other post in between, and so are delivered in that order
+// when asynchronous delivery is ordered.  Without ordering, the
+// deliveries of a batch may run concurrently with each other and with
+// those of other posts.  Payloads that fail validation are left out of
+// the batch and their errors returned in a MultiError, in batch order,
+// along with any error posting the rest of the batch.
+func (b *Bus) PostBatch(ps ...Payload) error {
+	var errs MultiError
+	batch := make([]rider, 0, len(ps))
+	for _, p := range ps {
+		p, err := b.prepare(p)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if b.duplicate(p) {
+			continue
+		}
+		batch = append(batch, rider{payload: p, mode: Asynchronous, ctx: context.Background()})
+	}
+	if len(batch) > 0 {
+		if b.logs(LogDebug) {
+			b.logger.Printf("Posting a batch of %v payloads.\n", len(batch))
+		}
+		if err := b.send(rider{batch: batch}); err != nil {
+			for _, m := range batch {
+				b.revoke(m.payload, err)
+			}
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// SetErrorHandler will register a function to be called with the
+// aggregated handler error, a MultiError, whenever the asynchronous
+// delivery of a payload posted with Post has failing handlers.  A nil
+// function removes the error handler.  Errors from PostAndWait are
+// returned to its caller instead.
+func (b *Bus) SetErrorHandler(fn func(p Payload, err error)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cfg.errorHandler = fn
+}
+
+// Use will add a middleware to the chain wrapped around every handler
+// at delivery time.  Middleware runs in the order it was added, so the
+// first middleware added sees each invocation first.
+func (b *Bus) Use(mw Middleware) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	chain := make([]Middleware, 0, len(b.cfg.middleware)+1)
+	chain = append(chain, b.cfg.middleware...)
+	b.cfg.middleware = append(chain, mw)
+}
+
+// SetDeadLetterHandler will register a function to be called with
+// every payload that matches no handler and no channel, counting those
+// registered for matching wildcards.  Such payloads usually point to
+// a typo in a payload type or a subsystem that was never wired up.  A
+// nil function removes the dead letter handler.
+func (b *Bus) SetDeadLetterHandler(fn func(p Payload)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cfg.deadLetter = fn
+}
+
+// Close will stop the bus goroutine and wait for it to exit.  Posts
+// that have not been picked up by the bus goroutine are rejected, and
+// every subsequent post returns an error.  Deliveries already under
+// way are allowed to finish, except that sends blocked on a subscriber
+// channel are abandoned, and scheduled posts are cancelled.  Closing a
+// closed bus is harmless.
+func (b *Bus) Close() error {
+	b.once.Do(func() {
+		if b.logs(LogInfo) {
+			b.logger.Printf("Closing the bus.")
+		}
+		b.stopTimers()
+		close(b.quit)
+		b.stopCoalescing()
+		<-b.stopped
+
+		// Once no post is in progress, reject whatever is left in
+		// the buffer of the posting channel.
+		b.gate.Lock()
+		b.gate.closed = true
+		b.gate.Unlock()
+		for {
+			select {
+			case r := <-b.pubchan:
+				b.reject(r)
+			default:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// prepare readies a payload for posting by passing it through the
+// enricher the bus was created with, if any, and validating the
+// result.
+func (b *Bus) prepare(p Payload) (Payload, error) {
+	if p != nil && b.enricher != nil {
+		typ := p.Type()
+		if p = b.enricher(p); p == nil {
+			message := fmt.Sprintf("Payload error: the enricher returned nil for payload with type: %v.", typ)
+			return nil, &busError{b.clock.Now(), message, CodeEmptyPayload, nil}
+		}
+	}
+	return p, b.validate(p)
+}
+
+// validate checks that a payload may be posted.
+func (b *Bus) validate(p Payload) error {
+	if p == nil {
+		message := "Payload error: a nil payload cannot be posted."
+		return &busError{b.clock.Now(), message, CodeEmptyPayload, nil}
+	}
+	if p.Type() == "" {
+		message := "Payload error: a payload with an empty type cannot be posted."
+		return &busError{b.clock.Now(), message, CodeEmptyPayload, nil}
+	}
+	if b.strict {
+		b.mu.RLock()
+		err := b.checkType(p.Type())
+		b.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+	}
+	if b.validator == nil {
+		return nil
+	}
+	if err := b.validator(p); err != nil {
+		message := fmt.Sprintf("Payload error: payload with type: %v is invalid: %v.", p.Type(), err)
+		return &busError{b.clock.Now(), message, CodeInvalidPayload, err}
+	}
+	return nil
+}
+
+// send hands a rider to the bus goroutine unless the bus is closed,
+// waiting for room in the posting channel unless the bus was created
+// with WithNonBlockingPosts.
+func (b *Bus) send(r rider) error {
+	return b.enqueue(r, !b.nonblocking)
+}
+
+// enqueue hands a rider to the bus goroutine unless the bus is closed,
+// or draining, except for the burst of a coalescer that started before
+// the bus was drained.  Unless block is set, it fails with ErrBusFull
+// rather than wait for room in the posting channel.
+func (b *Bus) enqueue(r rider, block bool) error {
+	b.gate.RLock()
+	defer b.gate.RUnlock()
+	admit := b.admit
+	if r.burst {
+		admit = b.open
+	}
+	if err := admit(); err != nil {
+		return err
+	}
+	if b.sequenced {
+		// A post waiting for room holds the lock, so the bus is full.
+		if block {
+			b.stamping.Lock()
+		} else if !b.stamping.TryLock() {
+			return b.fullError(r)
+		}
+		defer b.stamping.Unlock()
+	}
+	r.posted = b.clock.Now()
+	for i := range r.batch {
+		r.batch[i].posted = r.posted
+	}
+	b.stamp(r)
+	b.pending.Add(len(r.members()))
+	if !block {
+		select {
+		case b.pubchan <- r:
+		default:
+			b.unstamp(r)
+			for _, m := range r.members() {
+				b.settle(m)
+			}
+			return b.fullError(r)
+		}
+	} else {
+		select {
+		case b.pubchan <- r:
+		case <-b.quit:
+			b.unstamp(r)
+			for _, m := range r.members() {
+				b.settle(m)
+			}
+			return b.closedError()
+		}
+	}
+	for _, m := range r.members() {
+		atomic.AddUint64(&b.stats.of(m.payload.Type()).posted, 1)
+	}
+	return nil
+}
+
+// open returns an error if the bus is closed.  The caller must hold
+// the read lock of the gate.
+func (b *Bus) open() error {
+	if b.gate.closed {
+		return b.closedError()
+	}
+	select {
+	case <-b.quit:
+		return b.closedError()
+	default:
+	}
+	return nil
+}
+
+// fullError returns the error of a rider refused by a full bus.
+func (b *Bus) fullError(r rider) error {
+	message := fmt.Sprintf("Bus full: the batch of %v payloads could not be posted.", len(r.batch))
+	if r.batch == nil {
+		message = fmt.Sprintf("Bus full: the payload with type: %v could not be posted.", r.payload.Type())
+	}
+	return &busError{b.clock.Now(), message, CodeBusFull, nil}
+}
+
+// admit returns an error if the bus accepts no posts.  The caller must
+// hold the read lock of the gate.
+func (b *Bus) admit() error {
+	if err := b.open(); err != nil {
+		return err
+	}
+	if b.gate.draining {
+		message := "Bus draining: the payload could not be posted."
+		return &busError{b.clock.Now(), message, CodeBusDraining, nil}
+	}
+	return nil
+}
+
+// deliverInline delivers a synchronous rider on the calling goroutine,
+// bypassing the bus goroutine, for a bus created with
+// WithInlineSyncDelivery.
+func (b *Bus) deliverInline(r rider) error {
+	b.gate.RLock()
+	if err := b.admit(); err != nil {
+		b.gate.RUnlock()
+		return err
+	}
+	r.posted = b.clock.Now()
+	b.pending.Add(1)
+	b.gate.RUnlock()
+	b.stampNow(r)
+	atomic.AddUint64(&b.stats.of(r.payload.Type()).posted, 1)
+	defer b.settle(r)
+	b.deliver(r)
+	return <-r.done
+}
+
+// reject tells the poster of a rider that the bus closed before its
+// payload could be delivered.
+func (b *Bus) reject(r rider) {
+	if r.flushed != nil {
+		return
+	}
+	if r.batch != nil {
+		for _, m := range r.batch {
+			b.reject(m)
+		}
+		return
+	}
+	if b.logs(LogInfo) {
+		b.logger.Printf("Rejecting payload with type: %v, the bus is closed.\n", r.payload.Type())
+	}
+	if r.done != nil {
+		r.done <- b.closedError()
+	}
+	b.settle(r)
+}
+
+// settle marks a rider as no longer pending, whether it was delivered
+// or rejected, closing the done channel of an asynchronous rider.
+func (b *Bus) settle(r rider) {
+	if r.mode == Asynchronous && r.done != nil {
+		close(r.done)
+	}
+	if r.id != 0 {
+		b.forget(r.id)
+	}
+	b.pending.Done()
+}
+
+func (b *Bus) closedError() error {
+	message := "Bus closed: the payload could not be posted."
+	return &busError{b.clock.Now(), message, CodeBusClosed, nil}
+}
+
+// AddHandlers will register one or more handlers for a given payload
+// type.  Registering no handlers is an error.  The returned
+// Subscription can be passed to Unsubscribe to remove the handlers
+// again.  The handlers are appended, in argument order, after those
+// already registered for the type, and so run after them; removing
+// handlers keeps the order of the others, and only priorities and
+// ReorderHandlers change it.
+//
+// A type ending in ".*" is a wildcard that matches every payload type
+// starting with the text before the "*", so "user.*" matches
+// "user.created" and "user.address.changed" but not "user".  A payload
+// is delivered first to the handlers registered for its exact type and
+// then to those of each matching wildcard, the most specific (longest)
+// wildcard first.  Handlers are compared by function, so a handler
+// registered under several types matching the same payload runs only
+// once for it, at its most specific position.  The same rules apply to
+// subscriber channels.
+func (b *Bus) AddHandlers(typ string, fns ...Handler) (Subscription, error) {
+	if len(fns) == 0 {
+		message := "Argument error: at least one handler must be registered."
+		return Subscription{}, &busError{b.clock.Now(), message, CodeNoHandlers, nil}
+	}
+	entries := make([]*handlerEntry, len(fns))
+	for i, fn := range fns {
+		entries[i] = &handlerEntry{fn: fn}
+	}
+	return b.add(typ, entries)
+}
+
+// AddHandlersForTypes will register one or more handlers for each of
+// the given payload types, as AddHandlers would for each type in turn,
+// under a single Subscription.  Passing that Subscription to
+// Unsubscribe removes the handlers from every type at once.
+// Registering no handlers or for no types is an error.
+func (b *Bus) AddHandlersForTypes(types []string, fns ...Handler) (Subscription, error) {
+	if len(fns) == 0 {
+		message := "Argument error: at least one handler must be registered."
+		return Subscription{}, &busError{b.clock.Now(), message, CodeNoHandlers, nil}
+	}
+	if len(types) == 0 {
+		message := "Argument error: at least one payload type must be given."
+		return Subscription{}, &busError{b.clock.Now(), message, CodeInvalidArgument, nil}
+	}
+	s := Subscription{atomic.AddUint64(&b.nextID, 1)}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, typ := range types {
+		if err := b.checkType(typ); err != nil {
+			return Subscription{}, err
+		}
+	}
+	for _, typ := range types {
+		entries := make([]*handlerEntry, len(fns))
+		for i, fn := range fns {
+			entries[i] = &handlerEntry{id: s.id, fn: fn}
+		}
+		b.insert(typ, entries)
+		b.announce(MetaSubscribed, typ)
+	}
+	return s, nil
+}
+
+// AddHandlersInGroup will register one or more handlers for a given
+// payload type like AddHandlers, as members of the named handler
+// group.  No more than limit handlers of a group run at once across
+// the whole bus, whatever their payload types; delivery waits for a
+// place in the group before invoking a member.  Use a group to
+// throttle the handlers sharing a scarce resource.  The limit is set
+// when a group is first used and adding to it with a different limit
+// is an error.
+func (b *Bus) AddHandlersInGroup(group string, limit int, typ string, fns ...Handler) (Subscription, error) {
+	if len(fns) == 0 {
+		message := "Argument error: at least one handler must be registered."
+		return Subscription{}, &busError{b.clock.Now(), message, CodeNoHandlers, nil}
+	}
+	sem, err := b.group(group, limit)
+	if err != nil {
+		return Subscription{}, err
+	}
+	entries := make([]*handlerEntry, len(fns))
+	for i, fn := range fns {
+		entries[i] = &handlerEntry{fn: fn, sem: sem}
+	}
+	return b.add(typ, entries)
+}
+
+// group returns the semaphore of a handler group, creating it with the
+// given limit as needed.
+func (b *Bus) group(group string, limit int) (chan struct{}, error) {
+	if limit <= 0 {
+		message := fmt.Sprintf("Argument error: the limit of handler group: %v must be positive, not %v.", group, limit)
+		return nil, &busError{b.clock.Now(), message, CodeInvalidArgument, nil}
+	}
+	b.mu.Lock()
+	sem, ok := b.groups[group]
+	if !ok {
+		sem = make(chan struct{}, limit)
+		b.groups[group] = sem
+	}
+	b.mu.Unlock()
+	if cap(sem) != limit {
+		message := fmt.Sprintf("Argument error: handler group: %v has limit %v, not %v.", group, cap(sem), limit)
+		return nil, &busError{b.clock.Now(), message, CodeInvalidArgument, nil}
+	}
+	return sem, nil
+}
+
+// AddContextHandlers will register one or more context aware handlers
+// for a given payload type.  They are delivered payloads alongside the
+// plain handlers, in registration order, and receive the context of
+// PostWithContext or context.Background() for the other posts.
+func (b *Bus) AddContextHandlers(typ string, fns ...ContextHandler) (Subscription, error) {
+	if len(fns) == 0 {
+		message := "Argument error: at least one handler must be registered."
+		return Subscription{}, &busError{b.clock.Now(), message, CodeNoHandlers, nil}
+	}
+	entries := make([]*handlerEntry, len(fns))
+	for i, fn := range fns {
+		entries[i] = &handlerEntry{cfn: fn}
+	}
+	return b.add(typ, entries)
+}
+
+// AddModeHandlers will register one or more mode aware handlers for a
+// given payload type.  They are delivered payloads alongside the plain
+// handlers, in registration order, and receive Synchronous when the
+// poster waits for the delivery, as with PostAndWait, and Asynchronous
+// otherwise, as with Post.
+func (b *Bus) AddModeHandlers(typ string, fns ...ModeHandler) (Subscription, error) {
+	if len(fns) == 0 {
+		message := "Argument error: at least one handler must be registered."
+		return Subscription{}, &busError{b.clock.Now(), message, CodeNoHandlers, nil}
+	}
+	entries := make([]*handlerEntry, len(fns))
+	for i, fn := range fns {
+		entries[i] = &handlerEntry{mfn: fn}
+	}
+	return b.add(typ, entries)
+}
+
+// AddOnceHandlers will register one or more handlers for a given
+// payload type that are each invoked for the first matching payload
+// only and then removed.  A once handler runs exactly once even when
+// several payloads of its type are delivered concurrently.
+func (b *Bus) AddOnceHandlers(typ string, fns ...Handler) (Subscription, error) {
+	if len(fns) == 0 {
+		message := "Argument error: at least one handler must be registered."
+		return Subscription{}, &busError{b.clock.Now(), message, CodeNoHandlers, nil}
+	}
+	entries := make([]*handlerEntry, len(fns))
+	for i, fn := range fns {
+		entries[i] = &handlerEntry{fn: fn, once: true}
+	}
+	return b.add(typ, entries)
+}
+
+// AddHandlersWithPriority will register one or more handlers for a
+// given payload type like AddHandlers, at the given priority.  The
+// handlers matching a payload are invoked in priority order, lowest
+// first, and in registration order among equal priorities; AddHandlers
+// uses priority 0.  Priorities order the handlers of one payload only:
+// asynchronous payloads are delivered concurrently on several workers,
+// so the handlers of different payloads may still interleave.
+func (b *Bus) AddHandlersWithPriority(typ string, priority int, fns ...Handler) (Subscription, error) {
+	if len(fns) == 0 {
+		message := "Argument error: at least one handler must be registered."
+		return Subscription{}, &busError{b.clock.Now(), message, CodeNoHandlers, nil}
+	}
+	entries := make([]*handlerEntry, len(fns))
+	for i, fn := range fns {
+		entries[i] = &handlerEntry{fn: fn, priority: priority}
+	}
+	return b.add(typ, entries)
+}
+
+// AddFilteredHandler will register a handler for a given payload type
+// that is only invoked for the payloads accepted by filter.  The filter
+// runs on the delivering goroutine just before the handler would, so
+// it should be cheap and must not block.  A panicking filter rejects
+// the payload.
+func (b *Bus) AddFilteredHandler(typ string, filter func(p Payload) bool, h Handler) (Subscription, error) {
+	if filter == nil || h == nil {
+		message := "Argument error: a filter and a handler must be provided."
+		return Subscription{}, &busError{b.clock.Now(), message, CodeInvalidArgument, nil}
+	}
+	return b.add(typ, []*handlerEntry{{fn: h, filter: filter}})
+}
+
+// add registers entries for a given type under a new subscription.
+func (b *Bus) add(typ string, entries []*handlerEntry) (Subscription, error) {
+	s := Subscription{atomic.AddUint64(&b.nextID, 1)}
+	for _, e := range entries {
+		e.id = s.id
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.checkType(typ); err != nil {
+		return Subscription{}, err
+	}
+	b.insert(typ, entries)
+	b.announce(MetaSubscribed, typ)
+	return s, nil
+}
+
+// insert appends entries to the handlers registered for a given type,
+// keeping the list in priority order.  The caller must hold the lock.
+func (b *Bus) insert(typ string, entries []*handlerEntry) {
+	for _, e := range entries {
+		e.breaker = b.newBreaker()
+	}
+	list := make([]*handlerEntry, 0, len(b.handlers[typ])+len(entries))
+	list = append(list, b.handlers[typ]...)
+	list = append(list, entries...)
+	sortByPriority(list)
+	b.replayHandlers(typ, list, entries)
+	b.handlers[typ] = list
+}
+
+// sortByPriority sorts handler entries by priority, keeping the order
+// of entries with equal priorities.
+func sortByPriority(entries []*handlerEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].priority < entries[j].priority
+	})
+}
+
+// RemoveHandlers will remove every handler registered for a given
+// payload type and return the number of handlers removed.
+func (b *Bus) RemoveHandlers(typ string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := len(b.handlers[typ])
+	release(b.handlers[typ])
+	delete(b.handlers, typ)
+	if n > 0 {
+		b.announce(MetaUnsubscribed, typ)
+	}
+	return n
+}
+
+// SetHandlers will replace every handler registered for a given
+// payload type with the given handlers, under a single Subscription,
+// where AddHandlers adds to them.  The replacement is atomic: each
+// payload is delivered to either the complete old set or the complete
+// new set, never a mix of the two, which makes SetHandlers suited to
+// reconfiguring a running bus.  Setting no handlers removes them all,
+// as RemoveHandlers does, and returns the zero Subscription.
+func (b *Bus) SetHandlers(typ string, fns ...Handler) (Subscription, error) {
+	var s Subscription
+	entries := make([]*handlerEntry, len(fns))
+	if len(fns) > 0 {
+		s = Subscription{atomic.AddUint64(&b.nextID, 1)}
+	}
+	for i, fn := range fns {
+		entries[i] = &handlerEntry{id: s.id, fn: fn}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.checkType(typ); err != nil {
+		return Subscription{}, err
+	}
+	if len(b.handlers[typ]) > 0 {
+		release(b.handlers[typ])
+		delete(b.handlers, typ)
+		b.announce(MetaUnsubscribed, typ)
+	}
+	if len(entries) > 0 {
+		b.insert(typ, entries)
+		b.announce(MetaSubscribed, typ)
+	}
+	return s, nil
+}
+
+// ReorderHandlers will set the order in which the handlers currently
+// registered for a given payload type or wildcard run: order lists the
+// indexes of the handlers in their current order, as HandlerCount
+// counts them, so that order[0] names the handler to run first.  It
+// must be a permutation of the indexes, or an ErrInvalidArgument error
+// is returned and the order is left as it was.  A later registration
+// for the type keeps the new order among the handlers of equal
+// priority but sorts them by priority again.
+func (b *Bus) ReorderHandlers(typ string, order []int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entries := b.handlers[typ]
+	if len(order) != len(entries) {
+		message := fmt.Sprintf("Argument error: the order of the %v handlers for type: %v has %v indexes.", len(entries), typ, len(order))
+		return &busError{b.clock.Now(), message, CodeInvalidArgument, nil}
+	}
+	list := make([]*handlerEntry, len(entries))
+	used := make([]bool, len(entries))
+	for i, j := range order {
+		if j < 0 || j >= len(entries) || used[j] {
+			message := fmt.Sprintf("Argument error: index %v of the order for type: %v is out of range or repeated.", j, typ)
+			return &busError{b.clock.Now(), message, CodeInvalidArgument, nil}
+		}
+		used[j] = true
+		list[i] = entries[j]
+	}
+	b.handlers[typ] = list
+	return nil
+}
+
+// Unsubscribe will remove the handlers registered by the call to
+// AddHandlers that returned s and return the number of handlers
+// removed.  Unsubscribing more than once is harmless.
+//
+// The handler lists are replaced rather than modified in place so a
+// delivery that is already iterating over the old list is unaffected.
+func (b *Bus) Unsubscribe(s Subscription) int {
+	return b.removeIf(func(e *handlerEntry) bool { return e.id == s.id })
+}
+
+// removeIf removes every handler entry for which fn returns true and
+// returns the number removed.
+func (b *Bus) removeIf(fn func(e *handlerEntry) bool) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for typ, entries := range b.handlers {
+		kept := make([]*handlerEntry, 0, len(entries))
+		var removed []*handlerEntry
+		for _, e := range entries {
+			if fn(e) {
+				removed = append(removed, e)
+			} else {
+				kept = append(kept, e)
+			}
+		}
+		if len(removed) > 0 {
+			release(removed)
+			n += len(removed)
+			if len(kept) == 0 {
+				delete(b.handlers, typ)
+			} else {
+				b.handlers[typ] = kept
+			}
+			b.announce(MetaUnsubscribed, typ)
+		}
+	}
+	return n
+}
+
+// AddChannel will register a channel for a given payload type.  Sends
+// to the channel block until it accepts the payload.
+func (b *Bus) AddChannel(typ string, c chan Payload) {
+	b.AddChannelWithOptions(typ, c, ChannelOptions{})
+}
+
+// AddChannelWithOptions will register a channel for a given payload
+// type, sending to it as directed by opts.
+func (b *Bus) AddChannelWithOptions(typ string, c chan Payload, opts ChannelOptions) {
+	b.addChannel(typ, c, opts)
+}
+
+// AddChannelContext will register a channel for a given payload type
+// until ctx is done, when the channel is removed as if by RemoveChannel.
+// This suits subscriptions scoped to a request, which then need no
+// teardown of their own.  The goroutine watching ctx exits once the
+// channel is removed, whatever removes it, or the bus closes.
+func (b *Bus) AddChannelContext(ctx context.Context, typ string, c chan Payload) {
+	ce := b.addChannel(typ, c, ChannelOptions{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			b.removeChannel(typ, func(o *channelEntry) bool { return o == ce })
+		case <-ce.done:
+		case <-b.quit:
+		}
+	}()
+}
+
+func (b *Bus) addChannel(typ string, c chan Payload, opts ChannelOptions) *channelEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	list := make([]*channelEntry, 0, len(b.subchans[typ])+1)
+	list = append(list, b.subchans[typ]...)
+	if err := b.checkType(typ); err != nil && b.logs(LogError) {
+		b.logger.Printf("Registering a channel: %v\n", err)
+	}
+	ce := newChannelEntry(c, opts)
+	b.replayChannel(typ, ce)
+	b.subchans[typ] = append(list, ce)
+	b.announce(MetaSubscribed, typ)
+	return ce
+}
+
+// RemoveChannel will remove a channel registered for a given payload
+// type and report whether it was registered.  Once RemoveChannel
+// returns, the bus sends nothing more to the channel for that type; a
+// send already waiting on the channel is abandoned.  Both the channels
+// passed to AddChannel and those returned by NewChannel can be removed.
+func (b *Bus) RemoveChannel(typ string, c <-chan Payload) bool {
+	return b.removeChannel(typ, func(ce *channelEntry) bool { return ce.c == c })
+}
 
-const (
-	synchronous  flag = 1 << iota
-	asynchronous flag = 1 << iota
-)
+// removeChannel removes the first channel registered for typ that
+// matches and reports whether there was one.
+func (b *Bus) removeChannel(typ string, matches func(ce *channelEntry) bool) bool {
+	b.mu.Lock()
+	var found *channelEntry
+	list := b.subchans[typ]
+	kept := make([]*channelEntry, 0, len(list))
+	for _, ce := range list {
+		if found == nil && matches(ce) {
+			found = ce
+		} else {
+			kept = append(kept, ce)
+		}
+	}
+	if len(kept) == 0 {
+		delete(b.subchans, typ)
+	} else {
+		b.subchans[typ] = kept
+	}
+	if found != nil {
+		b.announce(MetaUnsubscribed, typ)
+	}
+	b.mu.Unlock()
+	if found == nil {
+		return false
+	}
+	found.remove()
+	return true
+}
 
-// A rider carries a payload and a delivery mode.
-type rider struct {
-	payload Payload
-	mode    flag
+// NewChannel will create a channel with the given buffer size, register
+// it for a given payload type like AddChannel and return its receiving
+// end, so that the caller cannot send on it by mistake.  A buffer size
+// of zero gives an unbuffered channel, exactly as if it were passed to
+// AddChannel.  Pass the channel to RemoveChannel to remove it; the bus
+// never closes it.
+func (b *Bus) NewChannel(typ string, buffer int) <-chan Payload {
+	c := make(chan Payload, buffer)
+	b.AddChannel(typ, c)
+	return c
 }
 
-// A Bus instance will communicate Payload objects to other goroutines
-// using a channel and/or a list of handlers.
-type Bus struct {
-	pubchan  chan rider
-	subchans map[string][]chan Payload
-	handlers map[string][]Handler
-	flags    map[Payload]flag
+// The buffer size of the channels created by Subscribe.
+const subscribeBuffer = 64
+
+// Subscribe will create a buffered channel, register it for a given
+// payload type and return it along with a function that cancels the
+// subscription.  Cancelling removes the channel and then closes it, so
+// a loop ranging over the channel ends once it has read the payloads
+// already buffered.  Calling the cancel function more than once is
+// harmless.
+func (b *Bus) Subscribe(typ string) (<-chan Payload, func()) {
+	c := make(chan Payload, subscribeBuffer)
+	b.AddChannel(typ, c)
+	var once sync.Once
+	return c, func() {
+		once.Do(func() {
+			b.RemoveChannel(typ, c)
+			close(c)
+		})
+	}
 }
 
-// Log a message using the configuration established by the bus package.
-func (b Bus) Log(message string) {
-	log.Print(message)
+// HandlerCount returns the number of handlers registered for a given
+// payload type or wildcard, not counting those of matching wildcards.
+func (b *Bus) HandlerCount(typ string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.handlers[typ])
 }
 
-// Post will asynchonously notify all subscribers that a payload of a
-// certain type is available.
-func (b Bus) Post(p Payload) error {
-	log.Printf("Posting payload of type: %v.\n", p.Type())
-	r := rider{p, asynchronous}
-	b.pubchan <- r
-	return nil
+// ChannelCount returns the number of channels registered for a given
+// payload type or wildcard, not counting those of matching wildcards.
+func (b *Bus) ChannelCount(typ string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subchans[typ])
 }
 
-// PostAndWait synchronously notifies all subscribers.
-func (b Bus) PostAndWait(p Payload) error {
-	log.Printf("Posting payload of type: %v.\n", p.Type())
-	r := rider{p, synchronous}
-	b.pubchan <- r
-	return nil
+// ChannelTypes returns, in sorted order, every payload type and
+// wildcard a given channel is registered for.  Together with Topology
+// it lets a debug dump show how subscribers are wired.
+func (b *Bus) ChannelTypes(c <-chan Payload) []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var types []string
+	for typ, subchans := range b.subchans {
+		for _, ce := range subchans {
+			if ce.c == c {
+				types = append(types, typ)
+				break
+			}
+		}
+	}
+	sort.Strings(types)
+	return types
 }
 
-// AddHandlers will register one or more handlers for a given payload
-// type.  Registering no handlers is an error.
-func (b Bus) AddHandlers(typ string, fns ...Handler) error {
-	if len(fns) > 0 {
-		b.handlers[typ] = append(b.handlers[typ], fns...)
-		return nil
+// Subscribers counts the handlers and channels registered for one
+// payload type or wildcard.
+type Subscribers struct {
+	Handlers int
+	Channels int
+}
+
+// Topology returns the number of handlers and channels registered for
+// every payload type and wildcard that has any, not counting those of
+// matching wildcards.
+func (b *Bus) Topology() map[string]Subscribers {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	topology := make(map[string]Subscribers)
+	for typ, entries := range b.handlers {
+		if len(entries) > 0 {
+			topology[typ] = Subscribers{Handlers: len(entries)}
+		}
+	}
+	for typ, subchans := range b.subchans {
+		if len(subchans) > 0 {
+			s := topology[typ]
+			s.Channels = len(subchans)
+			topology[typ] = s
+		}
 	}
-	message := "Argument error: at least one handler must be registered."
-	return &busError{time.Now(), message}
+	return topology
 }
 
-// AddChannel will register a channel for a given payload type.
-func (b Bus) AddChannel(typ string, c chan Payload) {
-	if _, ok := b.subchans[typ]; !ok {
-		b.subchans[typ] = []chan Payload{c}
-	} else {
-		subchans := b.subchans[typ]
-		b.subchans[typ] = append(subchans, c)
+// Types returns, in sorted order, every payload type and wildcard with
+// at least one handler or channel registered for it.
+func (b *Bus) Types() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var types []string
+	for typ, entries := range b.handlers {
+		if len(entries) > 0 {
+			types = append(types, typ)
+		}
+	}
+	for typ, subchans := range b.subchans {
+		if _, ok := b.handlers[typ]; len(subchans) > 0 && !ok {
+			types = append(types, typ)
+		}
 	}
+	sort.Strings(types)
+	return types
 }
 
 // New will create a Bus object with a channel on which to post a
 // Payload object and empty sets of subscriber functions and
 // subscriber channels.  Lastly, the new Bus object will run a traffic
 // cop steering posted payloads to the handlers that will deal with
-// them.
-func New() Bus {
-	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.Lshortfile)
-	log.Printf("Creating a new bus that runs a traffic cop to handle posted payloads.")
-	b := new(Bus)
-	b.pubchan = make(chan rider)
-	b.subchans = make(map[string][]chan Payload)
-	b.handlers = make(map[string][]Handler)
+// them.  Options adjust the defaults, which are an unbuffered posting
+// channel, one asynchronous delivery worker per CPU and a logger
+// writing to standard error, with timestamps and source locations, at
+// level LogError.
+// Every Bus owns its maps, goroutines and logger; the package keeps no
+// mutable state, so buses never share subscribers and creating one
+// leaves the standard logger untouched.
+func New(opts ...Option) *Bus {
+	b := &Bus{level: LogError}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if b.clock == nil {
+		b.clock = realClock{}
+	}
+	if b.logger == nil {
+		b.logger = log.New(os.Stderr, "", log.LstdFlags|log.Lmicroseconds|log.Lshortfile)
+	}
+	if b.logs(LogInfo) {
+		b.logger.Printf("Creating a new bus that runs a traffic cop to handle posted payloads.")
+	}
+	b.pubchan = make(chan rider, b.buffer)
+	b.work = make(chan rider)
+	if b.workers <= 0 {
+		b.workers = runtime.NumCPU()
+	}
+	b.quit = make(chan struct{})
+	b.stopped = make(chan struct{})
+	b.pausing = make(chan bool)
+	b.subchans = make(map[string][]*channelEntry)
+	b.handlers = make(map[string][]*handlerEntry)
+	b.modes = make(map[string]Mode)
+	b.groups = make(map[string]chan struct{})
+	b.known = make(map[string]bool)
+	b.limits = make(map[string]*limiter)
+	b.commands = make(map[string]Handler)
+	b.deliveries = make(map[uint64]context.CancelFunc)
+	b.stats = newCounters()
+	b.sched.timers = make(map[uint64]Timer)
+	for i := 0; i < b.workers; i++ {
+		go b.worker(b.work)
+	}
 	go b.run()
 
-	return *b
+	return b
+}
+
+// NewWithLogger will create a Bus object like New that logs to the
+// given logger.  It is shorthand for New(WithLogger(logger)).
+func NewWithLogger(logger Logger) *Bus {
+	return New(WithLogger(logger))
+}
+
+// A Code classifies the errors returned by the bus so that callers can
+// branch on the kind of error rather than on its message.  Every Code
+// is also an error and the exported sentinel errors are Codes, so
+// errors.Is(err, ErrBusClosed) reports whether err, or any error it
+// wraps, has CodeBusClosed, and errors.As(err, &code) extracts the Code
+// of a bus error.
+type Code int
+
+// The error codes.  CodeBusClosed means the bus is gone for good while
+// CodeBusFull means it is only busy, so a retry may succeed.
+const (
+	CodeUnknown Code = iota
+	CodeNoHandlers
+	CodeEmptyPayload
+	CodeInvalidPayload
+	CodeInvalidArgument
+	CodeBusClosed
+	CodeBusFull
+	CodeChannelOverflow
+	CodeTimeout
+	CodeHandlerPanic
+	CodeTypeMismatch
+	CodeAlreadyAnswered
+	CodeBusDraining
+	CodeUnknownType
+	CodeNoCommandHandler
+)
+
+var codeNames = [...]string{
+	CodeUnknown:          "unknown error",
+	CodeNoHandlers:       "no handlers",
+	CodeEmptyPayload:     "empty payload",
+	CodeInvalidPayload:   "invalid payload",
+	CodeInvalidArgument:  "invalid argument",
+	CodeBusClosed:        "bus closed",
+	CodeBusFull:          "bus full",
+	CodeChannelOverflow:  "channel overflow",
+	CodeTimeout:          "timeout",
+	CodeHandlerPanic:     "handler panic",
+	CodeTypeMismatch:     "type mismatch",
+	CodeAlreadyAnswered:  "already answered",
+	CodeBusDraining:      "bus draining",
+	CodeUnknownType:      "unknown type",
+	CodeNoCommandHandler: "no command handler",
+}
+
+// String returns a short description of the code.
+func (c Code) String() string {
+	if c < 0 || int(c) >= len(codeNames) {
+		return fmt.Sprintf("code %d", int(c))
+	}
+	return codeNames[c]
+}
+
+// Error makes a Code usable as a sentinel error.
+func (c Code) Error() string {
+	return "bus: " + c.String()
 }
 
+// The sentinel errors for each error code, for use with errors.Is.
+var (
+	ErrNoHandlers       error = CodeNoHandlers
+	ErrEmptyPayload     error = CodeEmptyPayload
+	ErrInvalidPayload   error = CodeInvalidPayload
+	ErrInvalidArgument  error = CodeInvalidArgument
+	ErrBusClosed        error = CodeBusClosed
+	ErrBusFull          error = CodeBusFull
+	ErrChannelOverflow  error = CodeChannelOverflow
+	ErrTimeout          error = CodeTimeout
+	ErrHandlerPanic     error = CodeHandlerPanic
+	ErrTypeMismatch     error = CodeTypeMismatch
+	ErrAlreadyAnswered  error = CodeAlreadyAnswered
+	ErrBusDraining      error = CodeBusDraining
+	ErrUnknownType      error = CodeUnknownType
+	ErrNoCommandHandler error = CodeNoCommandHandler
+)
+
+// ErrStopPropagation is returned by a handler, possibly wrapped, to
+// consume the payload it was given: the handlers after it, in priority
+// order, are not invoked for the payload, while the global handlers
+// and the subscriber channels still receive it.  It is not a failure, so a handler
+// returning it counts as succeeding and its post reports no error.
+var ErrStopPropagation = errors.New("bus: stop propagation")
+
 type busError struct {
 	When time.Time
 	What string
+	Code Code
+	err  error
 }
 
 // Error provides a hook to access the latest error.
@@ -129,44 +1643,911 @@ func (e *busError) Error() string {
 	return fmt.Sprintf("at %v, %s", e.When, e.What)
 }
 
+// Unwrap exposes the underlying error, if any, to errors.Is.
+func (e *busError) Unwrap() error {
+	return e.err
+}
+
+// Is reports whether target is the Code of the error.
+func (e *busError) Is(target error) bool {
+	c, ok := target.(Code)
+	return ok && c == e.Code
+}
+
+// As stores the Code of the error in target when target is a *Code.
+func (e *busError) As(target interface{}) bool {
+	c, ok := target.(*Code)
+	if ok {
+		*c = e.Code
+	}
+	return ok
+}
+
 // Run the bus to listen for posts.
-func (b Bus) run() {
-	log.Println("Bus is running.")
-	for r := range b.pubchan {
-		// Distribute the payload carried by the rider to the
-		// registered handlers and subscribers.
-		log.Printf("Broadcasting payload with type: %v, %v.\n", r.payload.Type(), b.modestring(r.mode))
-		if r.mode == asynchronous {
-			// Deliver the payload carried by the rider asynchronously.
-			go b.deliver(r)
-		} else {
-			// Deliver the payload carried by the rider synchronously.
+func (b *Bus) run() {
+	if b.logs(LogInfo) {
+		b.logger.Printf("Bus is running.")
+	}
+	defer close(b.stopped)
+	defer close(b.work)
+	lanes := make(map[string]chan rider)
+	defer func() {
+		for _, lane := range lanes {
+			close(lane)
+		}
+	}()
+	var paused bool
+	var held []rider
+	defer func() {
+		for _, r := range held {
+			b.reject(r)
+		}
+	}()
+	for {
+		var r rider
+		select {
+		case r = <-b.pubchan:
+		case paused = <-b.pausing:
+			if paused {
+				continue
+			}
+			if b.logs(LogInfo) {
+				b.logger.Printf("Resuming the bus with %v held payloads.\n", len(held))
+			}
+			for len(held) > 0 {
+				r, held = held[0], held[1:]
+				if !b.distribute(r, lanes) {
+					return
+				}
+			}
+			held = nil
+			continue
+		case <-b.quit:
+			if b.logs(LogInfo) {
+				b.logger.Printf("Bus is stopping.")
+			}
+			return
+		}
+		select {
+		case <-b.quit:
+			// The bus closed while both channels were ready.
+			b.reject(r)
+			if b.logs(LogInfo) {
+				b.logger.Printf("Bus is stopping.")
+			}
+			return
+		default:
+		}
+		if r.flushed != nil {
+			close(r.flushed)
+			continue
+		}
+		if paused {
+			if len(held) < pauseBuffer {
+				held = append(held, r)
+			} else {
+				b.spill(r)
+			}
+			continue
+		}
+		if !b.distribute(r, lanes) {
+			return
+		}
+	}
+}
+
+// distribute dispatches a rider, or the riders of a batch one after
+// the other so that no other post comes between them.  It returns
+// false if the bus closed meanwhile.
+func (b *Bus) distribute(r rider, lanes map[string]chan rider) bool {
+	members := r.members()
+	for i, m := range members {
+		if !b.dispatch(m, lanes) {
+			for _, rest := range members[i+1:] {
+				b.reject(rest)
+			}
+			if b.logs(LogInfo) {
+				b.logger.Printf("Bus is stopping.")
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// The number of posts a paused bus holds before it drops further
+// posts.
+const pauseBuffer = 1024
+
+// spill drops a rider that does not fit in the pause buffer.  A
+// synchronous poster receives an ErrBusFull error.
+func (b *Bus) spill(r rider) {
+	if r.batch != nil {
+		for _, m := range r.batch {
+			b.spill(m)
+		}
+		return
+	}
+	if b.logs(LogError) {
+		b.logger.Printf("Dropping payload with type: %v, the pause buffer is full.\n", r.payload.Type())
+	}
+	if r.done != nil {
+		message := fmt.Sprintf("Bus full: the bus is paused and already holds %v payloads.", pauseBuffer)
+		r.done <- &busError{b.clock.Now(), message, CodeBusFull, nil}
+	}
+	b.settle(r)
+}
+
+// dispatch distributes the payload carried by a rider to the registered
+// handlers and subscribers.  It reports false, having rejected the
+// rider, if the bus closed while waiting for a worker.
+func (b *Bus) dispatch(r rider, lanes map[string]chan rider) bool {
+	if b.traces("broadcast", r.payload.Type()) {
+		b.logger.Printf("Broadcasting payload with type: %v, %v.\n", r.payload.Type(), r.mode)
+	}
+	if r.mode == Synchronous {
+		// Deliver the payload carried by the rider synchronously, for
+		// its poster, on a goroutine of its own so that the bus
+		// goroutine stays free to accept the posts its handlers make.
+		go func() {
 			b.deliver(r)
+			b.settle(r)
+		}()
+		return true
+	}
+
+	// Deliver the payload carried by the rider asynchronously on the
+	// lane for its type, when delivery is ordered, or else on the first
+	// free worker.
+	work := b.work
+	if b.ordered {
+		typ := r.payload.Type()
+		if work = lanes[typ]; work == nil {
+			work = make(chan rider, laneBuffer)
+			lanes[typ] = work
+			go b.worker(work)
+		}
+	}
+	select {
+	case work <- r:
+		return true
+	case <-b.quit:
+		b.reject(r)
+		return false
+	}
+}
+
+// The number of riders that can wait in the lane of one payload type
+// when asynchronous delivery is ordered.
+const laneBuffer = 256
+
+// A worker delivers the asynchronous riders it receives, in order,
+// until the bus closes.
+func (b *Bus) worker(work chan rider) {
+	for r := range work {
+		atomic.AddInt64(&b.inflight, 1)
+		b.deliver(r)
+		atomic.AddInt64(&b.inflight, -1)
+		b.settle(r)
+	}
+}
+
+// Flush blocks until the bus goroutine has picked up every payload
+// posted before Flush was called, so that each has at least started
+// delivery, or been held by a paused bus, without waiting for the
+// deliveries to finish as Wait does.  It posts a marker behind those
+// payloads and waits for the marker to be picked up, so it works
+// whatever the buffer of the posting channel.  Flushing a closed or
+// draining bus returns an error.
+func (b *Bus) Flush() error {
+	r := rider{flushed: make(chan struct{})}
+	if err := b.enqueue(r, true); err != nil {
+		return err
+	}
+	select {
+	case <-r.flushed:
+		return nil
+	case <-b.stopped:
+		return b.closedError()
+	}
+}
+
+// Wait blocks until every payload posted, asynchronously or not, has
+// been delivered or rejected, so a graceful shutdown can post its last
+// payloads, call Wait and then Close.  Posts made while Wait is
+// blocked are waited for too.  Wait must not be called from a handler,
+// since the delivery of that handler's payload is one of those waited
+// for.
+func (b *Bus) Wait() {
+	b.pending.Wait()
+}
+
+// Drain will stop the bus from accepting posts, which then fail with
+// ErrBusDraining, and wait up to timeout for the payloads already
+// posted to be delivered.  It returns a timeout error if deliveries are
+// still pending when timeout expires.  Bursts held by WithCoalesce are
+// delivered at once rather than at the end of their window.  Draining
+// cannot be undone; it is the first phase of a shutdown whose second
+// phase is Close.  Like Wait, Drain must not be called from a handler.
+func (b *Bus) Drain(timeout time.Duration) error {
+	if b.logs(LogInfo) {
+		b.logger.Printf("Draining the bus.")
+	}
+	b.gate.Lock()
+	b.gate.draining = true
+	b.gate.Unlock()
+	b.flushBursts()
+	t := b.clock.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-b.pending.zero():
+		return nil
+	case <-t.C():
+		message := fmt.Sprintf("Timeout error: deliveries were still pending after draining for %v.", timeout)
+		return &busError{b.clock.Now(), message, CodeTimeout, nil}
+	}
+}
+
+// Pause stops the bus from delivering payloads without refusing posts.
+// Payloads posted while the bus is paused are held, up to a limit of
+// 1024 posts past which they are dropped and logged, and delivered in
+// order by Resume.  A synchronous post to a paused bus blocks until
+// the bus resumes, and so does Wait.  Pausing a paused or closed bus
+// has no effect.
+func (b *Bus) Pause() {
+	b.setPaused(true)
+}
+
+// Resume delivers the payloads held since Pause, in the order they
+// were posted, and lets delivery continue as usual.
+func (b *Bus) Resume() {
+	b.setPaused(false)
+}
+
+func (b *Bus) setPaused(paused bool) {
+	if b.logs(LogInfo) && paused {
+		b.logger.Printf("Pausing the bus.")
+	}
+	select {
+	case b.pausing <- paused:
+		if paused {
+			atomic.StoreInt32(&b.paused, 1)
+		} else {
+			atomic.StoreInt32(&b.paused, 0)
 		}
+	case <-b.stopped:
+	}
+}
+
+// A State tells whether a bus is delivering payloads.
+type State int
+
+// The states of a bus.  A Running bus accepts posts and delivers them,
+// a Paused one accepts posts and holds them until resumed, a Draining
+// one refuses posts and delivers those it has accepted and a Closed one
+// does nothing.
+const (
+	Running State = iota
+	Paused
+	Draining
+	Closed
+)
+
+var stateNames = [...]string{
+	Running:  "running",
+	Paused:   "paused",
+	Draining: "draining",
+	Closed:   "closed",
+}
+
+// String returns the name of the state.
+func (s State) String() string {
+	if s < 0 || int(s) >= len(stateNames) {
+		return fmt.Sprintf("state %d", int(s))
+	}
+	return stateNames[s]
+}
+
+// State returns the current state of the bus.  A bus being closed is
+// Closed as soon as Close is called, and a draining bus that is also
+// paused is Draining.
+func (b *Bus) State() State {
+	select {
+	case <-b.quit:
+		return Closed
+	default:
 	}
-	log.Println("Bus is stopping.")
+	b.gate.RLock()
+	draining := b.gate.draining
+	b.gate.RUnlock()
+	switch {
+	case draining:
+		return Draining
+	case atomic.LoadInt32(&b.paused) == 1:
+		return Paused
+	}
+	return Running
+}
+
+// QueueLen returns the number of posted payloads waiting in the buffer
+// of the posting channel for the bus goroutine, the QueueDepth of
+// Stats.  A batch counts once.  Graphed over time it shows whether the
+// bus goroutine keeps up with the posters.
+func (b *Bus) QueueLen() int {
+	return len(b.pubchan)
+}
+
+// QueueCap returns the size of the buffer of the posting channel, set
+// with WithPubChanBuffer.  For the default unbuffered channel both
+// QueueCap and QueueLen return 0.
+func (b *Bus) QueueCap() int {
+	return cap(b.pubchan)
+}
+
+// InFlight returns the number of asynchronous deliveries currently
+// running on the workers.
+func (b *Bus) InFlight() int {
+	return int(atomic.LoadInt64(&b.inflight))
 }
 
-func (b Bus) deliver(r rider) {
-	// First deliver the payload to the handlers.
+func (b *Bus) deliver(r rider) {
+	// Wait for the turn of the payload when its type is rate limited.
 	typ := r.payload.Type()
-	for i, h := range b.handlers[typ] {
-		log.Printf("Processing payload with type: %v, and handler at index: %v.\n", typ, i)
-		err := h(r.payload)
+	b.mu.RLock()
+	l := b.limiterOf(typ)
+	errorHandler := b.cfg.errorHandler
+	b.mu.RUnlock()
+	if l != nil {
+		if ok, err := b.throttle(r.ctx, l, r.payload); !ok {
+			switch {
+			case r.done != nil:
+				r.done <- err
+			case err != nil && errorHandler != nil:
+				errorHandler(r.payload, err)
+			}
+			return
+		}
+	}
+
+	// Copy the subscriber lists under the read lock so that concurrent
+	// registrations cannot disturb the iteration below.
+	key := b.routingKey(r.payload)
+	b.mu.RLock()
+	entries, subchans := b.match(key)
+	b.retain(key, r.payload)
+	errorHandler = b.cfg.errorHandler
+	deadLetter := b.cfg.deadLetter
+	middleware := b.cfg.middleware
+	parent := b.forwardee(typ, len(entries) > 0 || len(subchans) > 0)
+	taps, tapchans := b.wiretaps(entries, subchans)
+	b.mu.RUnlock()
+	if len(entries) == 0 && len(subchans) == 0 {
+		if b.logs(LogInfo) {
+			b.logger.Printf("No subscribers for payload with type: %v.\n", typ)
+		}
+		if deadLetter != nil {
+			deadLetter(r.payload)
+		}
+	} else if len(subchans) == 0 && deadLetter != nil && allTripped(entries) {
+		if b.logs(LogInfo) {
+			b.logger.Printf("Only tripped handlers for payload with type: %v.\n", typ)
+		}
+		deadLetter(r.payload)
+	}
+	stoppable := len(entries)
+	entries, subchans = append(entries, taps...), append(subchans, tapchans...)
+
+	// Deliver the payload to the handlers and the channels in the
+	// configured order, stopping early if the context of the post is
+	// cancelled.  The results of the handlers come first either way.
+	tc := b.stats.of(typ)
+	if r.results != nil {
+		*r.results = make([]DeliveryResult, len(entries))
+		for i := range entries {
+			(*r.results)[i].Index = i
+		}
+	}
+	var herrs, serrs MultiError
+	var err, cerr error
+	var sent []DeliveryResult
+	handle := func() { herrs, err = b.handle(r, entries, stoppable, middleware, tc) }
+	send := func() { sent, serrs, cerr = b.sendAll(r, subchans) }
+	switch b.order {
+	case ChannelsFirst:
+		if send(); cerr == nil {
+			handle()
+		}
+	case Interleaved:
+		sending := make(chan struct{})
+		go func() {
+			defer close(sending)
+			send()
+		}()
+		handle()
+		<-sending
+	default:
+		if handle(); err == nil {
+			send()
+		}
+	}
+	if err == nil {
+		err = cerr
+	}
+	errs := append(herrs, serrs...)
+	if r.results != nil {
+		*r.results = append(*r.results, sent...)
+	}
+
+	// Finally forward the payload and report the outcome to the poster.
+	tc.record(r.posted, b.clock.Now())
+	if parent != nil {
+		b.forward(parent, r.payload)
+	}
+	if err == nil && len(errs) > 0 {
+		err = errs
+	}
+	switch {
+	case r.done != nil:
+		r.done <- err
+	case err != nil && errorHandler != nil:
+		errorHandler(r.payload, err)
+	}
+}
+
+// handle delivers a payload to the matching handlers in turn and
+// returns their errors, or the error of the context of the post if
+// that was cancelled before every handler ran, or the first handler
+// error of a synchronous delivery on a bus created with
+// WithStopOnError.  ErrStopPropagation skips only the rest of the
+// first stoppable entries, those matching the type, and not the
+// global handlers after them.
+func (b *Bus) handle(r rider, entries []*handlerEntry, stoppable int, middleware []Middleware, tc *typeCounters) (MultiError, error) {
+	if b.parallel {
+		return b.handleParallel(r, entries, middleware, tc)
+	}
+	var errs MultiError
+	for i := 0; i < len(entries); i++ {
+		herr, stop, err := b.handleEntry(r, i, entries[i], middleware, tc)
 		if err != nil {
-			log.Printf("Handler failed: %v.\n", h)
+			return errs, err
+		}
+		if herr != nil {
+			if b.stopOnError && r.mode == Synchronous {
+				return nil, herr
+			}
+			errs = append(errs, herr)
 		}
+		if stop && i < stoppable {
+			if b.logs(LogDebug) {
+				b.logger.Printf("Handler at index: %v stopped the propagation of payload with type: %v.\n", i, r.payload.Type())
+			}
+			i = stoppable - 1
+		}
+	}
+	return errs, nil
+}
+
+// handleParallel delivers a payload to the matching handlers at once,
+// up to maxParallelHandlers at a time, for a bus created with
+// WithParallelHandlers.  It waits for every handler and returns their
+// errors in handler order, or the error of the context of the post if
+// that was cancelled before every handler ran.
+func (b *Bus) handleParallel(r rider, entries []*handlerEntry, middleware []Middleware, tc *typeCounters) (MultiError, error) {
+	herrs := make([]error, len(entries))
+	var err error
+	var once sync.Once
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxParallelHandlers)
+	for i, e := range entries {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, e *handlerEntry) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			var cerr error
+			if herrs[i], _, cerr = b.handleEntry(r, i, e, middleware, tc); cerr != nil {
+				once.Do(func() { err = cerr })
+			}
+		}(i, e)
 	}
-	for i, c := range b.subchans[typ] {
-		// Now deliver the payload to the subsystems.
-		log.Printf("Processing payload with type: %v, and channel at index: %v.\n", typ, i)
-		c <- r.payload
+	wg.Wait()
+	var errs MultiError
+	for _, herr := range herrs {
+		if herr != nil {
+			errs = append(errs, herr)
+		}
 	}
+	return errs, err
 }
 
-func (b Bus) modestring(f flag) string {
-	if (f & asynchronous) == asynchronous {
-		return "asynchronously"
+// handleEntry delivers a payload to the handler of one entry, unless
+// its filter, once flag or circuit breaker skip it, and returns the
+// error of the handler and whether it stopped propagation, or the
+// error of the context of the post if that was cancelled first.
+func (b *Bus) handleEntry(r rider, i int, e *handlerEntry, middleware []Middleware, tc *typeCounters) (herr error, stop bool, err error) {
+	typ := r.payload.Type()
+	if err = awaitReplay(r.ctx, e.ready); err != nil {
+		if b.logs(LogInfo) {
+			b.logger.Printf("Delivery of payload with type: %v cancelled: %v.\n", typ, err)
+		}
+		return nil, false, err
 	}
-	return "synchronously"
+	return b.invokeEntry(r, i, e, middleware, tc)
+}
+
+// invokeEntry invokes the handler of one entry like handleEntry,
+// without waiting for a replay, so that the replay itself can invoke
+// the handler with it.
+func (b *Bus) invokeEntry(r rider, i int, e *handlerEntry, middleware []Middleware, tc *typeCounters) (herr error, stop bool, err error) {
+	typ := r.payload.Type()
+	if e.filter != nil && !b.accepts(e.filter, r.payload) {
+		return nil, false, nil
+	}
+	if e.once {
+		if !atomic.CompareAndSwapInt32(&e.fired, 0, 1) {
+			return nil, false, nil
+		}
+		b.removeIf(func(o *handlerEntry) bool { return o == e })
+	}
+	if !e.breaker.allow() {
+		if b.logs(LogDebug) {
+			b.logger.Printf("Skipping the tripped handler at index: %v for payload with type: %v.\n", i, typ)
+		}
+		return nil, false, nil
+	}
+	if b.traces("handler", typ) {
+		b.logger.Printf("Processing payload with type: %v, and handler at index: %v.\n", typ, i)
+	}
+	h := e.handler(handlerContext(r.ctx, r.payload), r.mode)
+	for j := len(middleware) - 1; j >= 0; j-- {
+		h = middleware[j](h)
+	}
+	call := h
+	if e.sem != nil {
+		if call, err = b.acquire(r.ctx, e.sem, h); err != nil {
+			e.breaker.abandon()
+			if b.logs(LogInfo) {
+				b.logger.Printf("Delivery of payload with type: %v cancelled: %v.\n", typ, err)
+			}
+			return nil, false, err
+		}
+	}
+	start := b.clock.Now()
+	herr = b.call(call, r.payload)
+	if herr != nil && b.attempts > 1 && !errors.Is(herr, ErrStopPropagation) {
+		herr = b.retry(r, e, h, herr, tc)
+	}
+	stop = errors.Is(herr, ErrStopPropagation)
+	if stop {
+		herr = nil
+	}
+	elapsed := b.clock.Now().Sub(start)
+	if r.results != nil {
+		(*r.results)[i] = DeliveryResult{i, true, herr, elapsed, nil}
+	}
+	if b.slow > 0 {
+		tc.timeHandler(i, elapsed)
+		if elapsed > b.slow {
+			atomic.AddUint64(&tc.slow, 1)
+			if b.logs(LogError) {
+				b.logger.Printf("Slow handler: the handler at index: %v for payload with type: %v ran for %v, over %v.\n", i, typ, elapsed, b.slow)
+			}
+		}
+	}
+	if e.breaker.record(herr) && b.logs(LogError) {
+		b.logger.Printf("Tripped the circuit breaker of the handler at index: %v for payload with type: %v.\n", i, typ)
+	}
+	if herr != nil {
+		if b.logs(LogError) {
+			b.logger.Printf("Handler at index: %v failed: %v.\n", i, herr)
+		}
+		if e.errs != nil && r.mode == Asynchronous {
+			e.errs.push(herr)
+		}
+		atomic.AddUint64(&tc.failed, 1)
+	} else {
+		atomic.AddUint64(&tc.succeeded, 1)
+	}
+	return herr, stop, nil
+}
+
+// sendAll sends a payload to the matching channels, every channel at
+// once so that a blocked channel cannot hold up the others, and returns
+// the results of the sends with their errors, or the error of the
+// context of the post if that was cancelled.  Delivery still waits for
+// every send, which keeps the payloads sent to any one channel in
+// order.
+func (b *Bus) sendAll(r rider, subchans []*channelEntry) ([]DeliveryResult, MultiError, error) {
+	var errs MultiError
+	sent := b.fanOut(r.ctx, subchans, r.payload)
+	for _, s := range sent {
+		if s.Err == nil {
+			continue
+		}
+		if err := r.ctx.Err(); err != nil {
+			return sent, errs, err
+		}
+		errs = append(errs, s.Err)
+	}
+	return sent, errs, nil
+}
+
+// routingKey returns the key the subscribers of a payload are looked up
+// by: the key computed by the routing key function the bus was created
+// with, unless that is empty, or else the payload type.
+func (b *Bus) routingKey(p Payload) string {
+	if b.router != nil {
+		if key := b.router(p); key != "" {
+			return key
+		}
+	}
+	return p.Type()
+}
+
+// match returns copies of the handlers and channels registered for a
+// payload type, followed by those registered for each wildcard that
+// matches it, most specific first, without duplicates.  The handlers
+// are then put in priority order.  The caller must hold the read lock.
+func (b *Bus) match(typ string) ([]*handlerEntry, []*channelEntry) {
+	entries := append([]*handlerEntry(nil), b.handlers[typ]...)
+	subchans := append([]*channelEntry(nil), b.subchans[typ]...)
+	owners := make(map[unsafe.Pointer]string)
+	for _, e := range entries {
+		owners[e.key()] = typ
+	}
+	for i := strings.LastIndex(typ, "."); i >= 0; i = strings.LastIndex(typ[:i], ".") {
+		pattern := typ[:i+1] + "*"
+		for _, e := range b.handlers[pattern] {
+			if owner, ok := owners[e.key()]; ok && owner != pattern {
+				continue
+			}
+			owners[e.key()] = pattern
+			entries = append(entries, e)
+		}
+		for _, ce := range b.subchans[pattern] {
+			if !containsChannel(subchans, ce.c) {
+				subchans = append(subchans, ce)
+			}
+		}
+	}
+	sortByPriority(entries)
+	return entries, subchans
+}
+
+func containsChannel(subchans []*channelEntry, c chan Payload) bool {
+	for _, ce := range subchans {
+		if ce.c == c {
+			return true
+		}
+	}
+	return false
+}
+
+// fanOut sends a payload to subscriber channels concurrently, as
+// directed by their options, and returns the result of each send.
+func (b *Bus) fanOut(ctx context.Context, subchans []*channelEntry, p Payload) []DeliveryResult {
+	sent := make([]DeliveryResult, len(subchans))
+	send := func(i int, ce *channelEntry) {
+		start := b.clock.Now()
+		ok, err := b.sendTo(ctx, ce, p)
+		if err == errClosedChannel {
+			if b.logs(LogError) {
+				b.logger.Printf("Removing a closed subscriber channel found delivering payload with type: %v.\n", p.Type())
+			}
+			b.prune(ce)
+			err = nil
+		}
+		sent[i] = DeliveryResult{i, ok, err, b.clock.Now().Sub(start), ce.c}
+	}
+	if len(subchans) == 1 {
+		send(0, subchans[0])
+		return sent
+	}
+	var wg sync.WaitGroup
+	for i, ce := range subchans {
+		if b.traces("channel", p.Type()) {
+			b.logger.Printf("Processing payload with type: %v, and channel at index: %v.\n", p.Type(), i)
+		}
+		wg.Add(1)
+		go func(i int, ce *channelEntry) {
+			defer wg.Done()
+			send(i, ce)
+		}(i, ce)
+	}
+	wg.Wait()
+	return sent
+}
+
+// sendTo sends a payload to a subscriber channel as directed by its
+// options and reports whether the channel accepted it.  It returns an
+// error if the send was abandoned because ctx was cancelled or if the
+// payload was dropped under OverflowError, and errClosedChannel if the
+// channel was closed by its subscriber.  A send blocked when the bus
+// closes, or when the channel is removed, is abandoned and skipped.
+// The send waits for the replay to the channel, if any, to finish.
+func (b *Bus) sendTo(ctx context.Context, ce *channelEntry, p Payload) (sent bool, err error) {
+	if err := awaitReplay(ctx, ce.ready); err != nil {
+		return false, err
+	}
+	return b.offer(ctx, ce, p)
+}
+
+// offer sends a payload to a subscriber channel like sendTo, without
+// waiting for a replay, so that the replay itself can send with it.
+func (b *Bus) offer(ctx context.Context, ce *channelEntry, p Payload) (sent bool, err error) {
+	ce.mu.RLock()
+	defer ce.mu.RUnlock()
+	defer func() {
+		if e := recover(); e != nil {
+			if re, ok := e.(runtime.Error); !ok || re.Error() != "send on closed channel" {
+				panic(e)
+			}
+			sent, err = false, errClosedChannel
+		}
+	}()
+	if ce.removed {
+		return false, nil
+	}
+	var timeout, grace <-chan time.Time
+	switch {
+	case ce.opts.SendTimeout > 0:
+		t := b.clock.NewTimer(ce.opts.SendTimeout)
+		defer t.Stop()
+		timeout = t.C()
+	case ce.opts.Overflow == OverflowBlock && b.grace > 0:
+		t := b.clock.NewTimer(b.grace)
+		defer t.Stop()
+		grace = t.C()
+	case ce.opts.Overflow != OverflowBlock:
+		select {
+		case ce.c <- p:
+			return true, nil
+		case <-ce.done:
+			return false, nil
+		default:
+		}
+		return false, b.overflow(ce, p)
+	}
+	select {
+	case ce.c <- p:
+		return true, nil
+	case <-ce.done:
+		return false, nil
+	case <-ctx.Done():
+		if b.logs(LogInfo) {
+			b.logger.Printf("Abandoning a send of payload with type: %v to a blocked subscriber channel: %v.\n", p.Type(), ctx.Err())
+		}
+		return false, ctx.Err()
+	case <-b.quit:
+		if b.logs(LogInfo) {
+			b.logger.Printf("Abandoning a send of payload with type: %v to a blocked subscriber channel: the bus closed.\n", p.Type())
+		}
+		return false, nil
+	case <-timeout:
+		return false, b.overflow(ce, p)
+	case <-grace:
+		if b.logs(LogError) {
+			b.logger.Printf("Warning: skipping a subscriber channel blocked for %v on payload with type: %v.\n", b.grace, p.Type())
+		}
+		return false, nil
+	}
+}
+
+// errClosedChannel tells that a send found a subscriber channel closed.
+var errClosedChannel = errors.New("send on closed channel")
+
+// prune removes a subscriber channel that was closed without being
+// removed, from whichever type it is registered for.
+func (b *Bus) prune(ce *channelEntry) {
+	b.mu.Lock()
+	var found bool
+	for typ, list := range b.subchans {
+		for i, o := range list {
+			if o != ce {
+				continue
+			}
+			found = true
+			kept := append(append([]*channelEntry(nil), list[:i]...), list[i+1:]...)
+			if len(kept) == 0 {
+				delete(b.subchans, typ)
+			} else {
+				b.subchans[typ] = kept
+			}
+			b.announce(MetaUnsubscribed, typ)
+			break
+		}
+		if found {
+			break
+		}
+	}
+	b.mu.Unlock()
+	if found {
+		ce.remove()
+	}
+}
+
+// overflow handles a payload a subscriber channel could not accept.
+func (b *Bus) overflow(ce *channelEntry, p Payload) error {
+	if b.logs(LogError) {
+		b.logger.Printf("Dropped payload with type: %v, the subscriber channel is full.\n", p.Type())
+	}
+	if ce.opts.Overflow != OverflowError {
+		return nil
+	}
+	message := fmt.Sprintf("Overflow error: a subscriber channel could not accept a payload with type: %v.", p.Type())
+	return &busError{b.clock.Now(), message, CodeChannelOverflow, nil}
+}
+
+// acquire waits for a place in a handler group, or for ctx to be
+// cancelled, and returns h wrapped to give the place up once it
+// returns, even if delivery stopped waiting for it.
+func (b *Bus) acquire(ctx context.Context, sem chan struct{}, h Handler) (Handler, error) {
+	select {
+	case sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return func(p Payload) error {
+		defer func() { <-sem }()
+		return h(p)
+	}, nil
+}
+
+// call invokes a handler, giving up on it once the handler timeout, if
+// any, expires.  A handler that times out keeps running on its own
+// goroutine, since it cannot be stopped, but delivery moves on.
+func (b *Bus) call(h Handler, p Payload) error {
+	if b.timeout <= 0 {
+		return b.invoke(h, p)
+	}
+	result := make(chan error, 1)
+	go func() { result <- b.invoke(h, p) }()
+	t := b.clock.NewTimer(b.timeout)
+	defer t.Stop()
+	select {
+	case err := <-result:
+		return err
+	case <-t.C():
+		if b.logs(LogError) {
+			b.logger.Printf("Handler timed out after %v on payload with type: %v.\n", b.timeout, p.Type())
+		}
+		message := fmt.Sprintf("Timeout error: a handler for payload with type: %v ran longer than %v.", p.Type(), b.timeout)
+		return &busError{b.clock.Now(), message, CodeTimeout, nil}
+	}
+}
+
+// accepts reports whether a handler filter accepts a payload.
+func (b *Bus) accepts(filter func(p Payload) bool, p Payload) (ok bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			if b.logs(LogError) {
+				b.logger.Printf("Filter panicked on payload with type: %v: %v.\n", p.Type(), v)
+			}
+			ok = false
+		}
+	}()
+	return filter(p)
+}
+
+// invoke calls a handler, recovering from a panic as the panic policy
+// directs so that, unless told otherwise, one bad handler cannot take
+// down the goroutine delivering the payload.
+func (b *Bus) invoke(h Handler, p Payload) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			if b.logs(LogError) {
+				b.logger.Printf("Handler panicked on payload with type: %v: %v\n%s", p.Type(), v, debug.Stack())
+			}
+			switch b.panics {
+			case PanicRepanic:
+				panic(v)
+			case PanicAsError:
+				message := fmt.Sprintf("Handler panic: %v", v)
+				err = &busError{b.clock.Now(), message, CodeHandlerPanic, nil}
+			}
+		}
+	}()
+	return h(p)
 }
@@ -8,10 +8,17 @@
 package bus
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/pajato/event"
 )
@@ -31,6 +38,48 @@ func TestZeroValues(t *testing.T) {
 	}
 }
 
+func TestNewWithLogger(t *testing.T) {
+	flags := log.Flags()
+	var buf bytes.Buffer
+	b := NewWithLogger(log.New(&buf, "", 0))
+	b.AddHandlers("testEventLogger", failWith(errors.New("failure")))
+	b.PostAndWait(event.New("testEventLogger"))
+	b.Close()
+	if !strings.Contains(buf.String(), "Handler at index: 0 failed: failure.") {
+		t.Errorf("The bus did not log to the supplied logger, which holds: %q.", buf.String())
+	}
+	New().Close()
+	if log.Flags() != flags {
+		t.Error("Creating a bus changed the flags of the standard logger.")
+	}
+}
+
+func TestLogLevels(t *testing.T) {
+	var buf bytes.Buffer
+	b := New(WithLogger(log.New(&buf, "", 0)), WithLogLevel(LogOff))
+	b.AddHandlers("testEventLevels", failWith(errors.New("failure")))
+	b.PostAndWait(event.New("testEventLevels"))
+	b.Log("message")
+	b.Close()
+	if buf.Len() != 0 {
+		t.Errorf("Nothing should be logged at level LogOff, but the log holds: %q.", buf.String())
+	}
+	buf.Reset()
+	b = New(WithLogger(log.New(&buf, "", 0)))
+	b.PostAndWait(event.New("testEventLevels"))
+	b.Close()
+	if strings.Contains(buf.String(), "Posting payload") {
+		t.Errorf("Posts should not be logged at the default level, but the log holds: %q.", buf.String())
+	}
+	buf.Reset()
+	b = New(WithLogger(log.New(&buf, "", 0)), WithLogLevel(LogDebug))
+	b.PostAndWait(event.New("testEventLevels"))
+	b.Close()
+	if !strings.Contains(buf.String(), "Posting payload of type: testEventLevels.") {
+		t.Errorf("Posts should be logged at level LogDebug, but the log holds: %q.", buf.String())
+	}
+}
+
 func TestEmptyMaps(t *testing.T) {
 	b := New()
 	if n := len(b.subchans); n != 0 {
@@ -45,7 +94,7 @@ func TestEmptyMaps(t *testing.T) {
 
 func TestError(t *testing.T) {
 	b := New()
-	err := b.AddHandlers("testName")
+	_, err := b.AddHandlers("testName")
 	if err == nil {
 		t.Error("AddChannels did not return an error as expected.")
 	} else {
@@ -69,6 +118,1680 @@ func TestAddHandlers(t *testing.T) {
 	}
 }
 
+func TestRemoveHandlers(t *testing.T) {
+	b := New()
+	name := "testName"
+	b.AddHandlers(name, h1, h2, h3)
+	if n := b.RemoveHandlers(name); n != 3 {
+		t.Errorf("RemoveHandlers should have removed 3 handlers, but removed: %v.", n)
+	}
+	if n := len(b.handlers[name]); n != 0 {
+		t.Errorf("The map of handlers should be 0, but is: %v.", n)
+	}
+	if n := b.RemoveHandlers(name); n != 0 {
+		t.Errorf("A second RemoveHandlers should have removed nothing, but removed: %v.", n)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	b := New()
+	name := "testName"
+	s1, _ := b.AddHandlers(name, h1, h2)
+	s2, _ := b.AddHandlers(name, h3)
+	if n := b.Unsubscribe(s1); n != 2 {
+		t.Errorf("Unsubscribe should have removed 2 handlers, but removed: %v.", n)
+	}
+	if n := len(b.handlers[name]); n != 1 {
+		t.Errorf("The map of handlers should be 1, but is: %v.", n)
+	}
+	if n := b.Unsubscribe(s1); n != 0 {
+		t.Errorf("A second Unsubscribe should have removed nothing, but removed: %v.", n)
+	}
+	b.Unsubscribe(s2)
+	if _, ok := b.handlers[name]; ok {
+		t.Error("The handler list for an emptied type was not removed.")
+	}
+}
+
+func TestSetHandlers(t *testing.T) {
+	b := New()
+	defer b.Close()
+	name := "testEventSetHandlers"
+	var mu sync.Mutex
+	var seen []string
+	record := func(tag string) Handler {
+		return func(p Payload) error {
+			mu.Lock()
+			seen = append(seen, tag)
+			mu.Unlock()
+			return nil
+		}
+	}
+	b.SetHandlers(name, record("old"), record("old"), record("old"))
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if i%2 == 0 {
+				b.SetHandlers(name, record("new"), record("new"))
+			} else {
+				b.SetHandlers(name, record("old"), record("old"), record("old"))
+			}
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		b.PostAndWait(event.New(name))
+		mu.Lock()
+		got := strings.Join(seen, " ")
+		seen = nil
+		mu.Unlock()
+		if got != "old old old" && got != "new new" {
+			t.Fatalf("A payload should see the complete old or new handlers, but saw: %v.", got)
+		}
+	}
+	close(stop)
+	<-done
+	if s, _ := b.SetHandlers(name); s != (Subscription{}) || len(b.handlers[name]) != 0 {
+		t.Error("Setting no handlers should remove them all.")
+	}
+}
+
+func TestReorderHandlers(t *testing.T) {
+	b := New()
+	defer b.Close()
+	name := "testEventReorder"
+	var order []int
+	for i := 0; i < 4; i++ {
+		i := i
+		b.AddHandlers(name, func(p Payload) error {
+			order = append(order, i)
+			return nil
+		})
+	}
+	if err := b.ReorderHandlers(name, []int{2, 0, 3, 1}); err != nil {
+		t.Fatalf("Reordering failed with message: %v.\n", err)
+	}
+	b.PostAndWait(event.New(name))
+	if fmt.Sprint(order) != "[2 0 3 1]" {
+		t.Errorf("The handlers should run in the new order, but ran: %v.", order)
+	}
+	for _, bad := range [][]int{{0, 1, 2}, {0, 1, 2, 4}, {0, 1, 1, 2}, {-1, 0, 1, 2}} {
+		if err := b.ReorderHandlers(name, bad); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("The order %v should be rejected, but gave: %v.", bad, err)
+		}
+	}
+}
+
+func TestUnsubscribeDuringDelivery(t *testing.T) {
+	b := New()
+	name := "testEventUnsubscribe"
+	count := 0
+	var s Subscription
+	s, _ = b.AddHandlers(name, func(p Payload) error {
+		b.Unsubscribe(s)
+		return nil
+	})
+	b.AddHandlers(name, func(p Payload) error {
+		count++
+		return nil
+	})
+	b.PostAndWait(event.New(name))
+	if count != 1 {
+		t.Errorf("The handler after the unsubscribing one should have run once, but ran: %v.", count)
+	}
+	if n := len(b.handlers[name]); n != 1 {
+		t.Errorf("The map of handlers should be 1, but is: %v.", n)
+	}
+}
+
+func TestPostAndWaitBlocks(t *testing.T) {
+	b := New()
+	name := "testEventWait"
+	c := make(chan Payload, 1)
+	ran := false
+	b.AddHandlers(name, func(p Payload) error {
+		time.Sleep(10 * time.Millisecond)
+		ran = true
+		return nil
+	})
+	b.AddChannel(name, c)
+	b.PostAndWait(event.New(name))
+	if !ran {
+		t.Error("PostAndWait returned before the handler completed.")
+	}
+	if n := len(c); n != 1 {
+		t.Errorf("The subscriber channel should hold 1 payload, but holds: %v.", n)
+	}
+}
+
+func TestChannelOptions(t *testing.T) {
+	b := New()
+	name := "testEventOverflow"
+	b.AddChannelWithOptions(name, make(chan Payload), ChannelOptions{Overflow: OverflowDrop})
+	if err := b.PostAndWait(event.New(name)); err != nil {
+		t.Errorf("A dropped payload should not fail the post, but it returned: %v.", err)
+	}
+	b.AddChannelWithOptions(name, make(chan Payload), ChannelOptions{Overflow: OverflowError})
+	if err := b.PostAndWait(event.New(name)); err == nil {
+		t.Error("An overflowing channel did not fail the post as expected.")
+	}
+	start := time.Now()
+	tname := "testEventTimeout"
+	b.AddChannelWithOptions(tname, make(chan Payload), ChannelOptions{SendTimeout: 20 * time.Millisecond})
+	b.PostAndWait(event.New(tname))
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("The send should have waited for its timeout, but took: %v.", elapsed)
+	}
+}
+
+func TestOnceHandlers(t *testing.T) {
+	b := New()
+	name := "startup.complete"
+	var count int32
+	b.AddOnceHandlers(name, func(p Payload) error {
+		atomic.AddInt32(&count, 1)
+		return nil
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.PostAndWait(event.New(name))
+		}()
+		b.Post(event.New(name))
+	}
+	wg.Wait()
+	b.Close()
+	if n := atomic.LoadInt32(&count); n != 1 {
+		t.Errorf("The once handler should have run once, but ran: %v.", n)
+	}
+	if n := b.HandlerCount(name); n != 0 {
+		t.Errorf("The once handler should have been removed, but %v handlers remain.", n)
+	}
+}
+
+func TestPriorities(t *testing.T) {
+	b := New()
+	name := "order.placed"
+	var calls []string
+	record := func(label string) Handler {
+		return func(p Payload) error {
+			calls = append(calls, label)
+			return nil
+		}
+	}
+	b.AddHandlers(name, record("default"))
+	b.AddHandlersWithPriority(name, 10, record("persist"))
+	b.AddHandlersWithPriority("order.*", -5, record("audit"))
+	b.AddHandlersWithPriority(name, -10, record("validate"))
+	b.AddHandlersWithPriority(name, 10, record("notify"))
+	b.PostAndWait(event.New(name))
+	want := "validate audit default persist notify"
+	if got := strings.Join(calls, " "); got != want {
+		t.Errorf("The handlers should run in the order %q, but ran: %q.", want, got)
+	}
+}
+
+func TestStopPropagation(t *testing.T) {
+	b := New()
+	defer b.Close()
+	name := "testEventStopPropagation"
+	later := 0
+	b.AddHandlers(name, func(p Payload) error {
+		later++
+		return nil
+	})
+	b.AddHandlersWithPriority(name, -1, func(p Payload) error {
+		return fmt.Errorf("consumed: %w", ErrStopPropagation)
+	})
+	c := make(chan Payload, 1)
+	b.AddChannel(name, c)
+	if err := b.PostAndWait(event.New(name)); err != nil {
+		t.Errorf("Stopping propagation should not fail the post, but gave: %v.", err)
+	}
+	if later != 0 {
+		t.Errorf("The handlers after the stop should be skipped, but one ran %v times.", later)
+	}
+	if len(c) != 1 {
+		t.Error("The channel should still receive the payload.")
+	}
+	<-c
+	global := 0
+	b.AddGlobalHandler(func(p Payload) error {
+		global++
+		return nil
+	})
+	b.PostAndWait(event.New(name))
+	if later != 0 || global != 1 {
+		t.Errorf("Only the global handler should run after the stop, but it ran %v times and the other handler %v.", global, later)
+	}
+}
+
+func TestDeadLetterHandler(t *testing.T) {
+	b := New()
+	var dead []string
+	b.SetDeadLetterHandler(func(p Payload) {
+		dead = append(dead, p.Type())
+	})
+	b.AddHandlers("user.created", h1)
+	b.AddChannel("order.*", make(chan Payload, 1))
+	b.PostAndWait(event.New("user.created"))
+	b.PostAndWait(event.New("order.placed"))
+	b.PostAndWait(event.New("user.craeted"))
+	if got := strings.Join(dead, " "); got != "user.craeted" {
+		t.Errorf("Only user.craeted should be a dead letter, but the dead letters are: %q.", got)
+	}
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	b := New(WithHandlerTimeout(20 * time.Millisecond))
+	name := "testEventSlow"
+	release := make(chan struct{})
+	defer close(release)
+	ran := false
+	b.AddHandlers(name, func(p Payload) error {
+		<-release
+		return nil
+	})
+	b.AddHandlers(name, func(p Payload) error {
+		ran = true
+		return nil
+	})
+	start := time.Now()
+	if err := b.PostAndWait(event.New(name)); err == nil {
+		t.Error("A timed out handler did not fail the post as expected.")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Delivery should have moved on after the timeout, but took: %v.", elapsed)
+	}
+	if !ran {
+		t.Error("The handler after a timed out handler did not run.")
+	}
+}
+
+func TestSlowHandlerThreshold(t *testing.T) {
+	var buf bytes.Buffer
+	b := New(WithLogger(log.New(&buf, "", 0)), WithSlowHandlerThreshold(10*time.Millisecond))
+	name := "testEventSlow"
+	b.AddHandlers(name, h1, func(p Payload) error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+	if err := b.PostAndWait(event.New(name)); err != nil {
+		t.Errorf("A slow handler should still succeed, but the post gave: %v.", err)
+	}
+	b.Close()
+	if !strings.Contains(buf.String(), "Slow handler: the handler at index: 1 for payload with type: testEventSlow") {
+		t.Errorf("The slow handler should be logged, but the log holds: %q.", buf.String())
+	}
+	ts := b.Stats().Types[name]
+	if ts.Slow != 1 || ts.SlowestIndex != 1 || ts.Slowest < 20*time.Millisecond {
+		t.Errorf("The slow handler should be counted, but the stats are: %+v.", ts)
+	}
+}
+
+func TestFilteredHandler(t *testing.T) {
+	b := New()
+	name := "order.placed"
+	var amounts []int
+	b.AddFilteredHandler(name, func(p Payload) bool {
+		return p.Data()["amount"].(int) > 100
+	}, func(p Payload) error {
+		amounts = append(amounts, p.Data()["amount"].(int))
+		return nil
+	})
+	for _, amount := range []int{50, 150, 100, 250} {
+		e := event.New(name)
+		e.Data()["amount"] = amount
+		b.PostAndWait(e)
+	}
+	b.PostAndWait(event.New(name))
+	if fmt.Sprint(amounts) != "[150 250]" {
+		t.Errorf("The filtered handler should see [150 250], but saw: %v.", amounts)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	b := New()
+	name := "testEventMiddleware"
+	var calls []string
+	trace := func(label string) Middleware {
+		return func(next Handler) Handler {
+			return func(p Payload) error {
+				calls = append(calls, label)
+				return next(p)
+			}
+		}
+	}
+	denied := errors.New("denied")
+	b.Use(trace("outer"))
+	b.Use(trace("inner"))
+	b.Use(func(next Handler) Handler {
+		return func(p Payload) error {
+			if p.Data()["deny"] == true {
+				return denied
+			}
+			return next(p)
+		}
+	})
+	b.AddHandlers(name, func(p Payload) error {
+		calls = append(calls, "handler")
+		return nil
+	})
+	b.PostAndWait(event.New(name))
+	e := event.New(name)
+	e.Data()["deny"] = true
+	if err := b.PostAndWait(e); !errors.Is(err, denied) {
+		t.Errorf("The short-circuiting middleware error was not returned, got: %v.", err)
+	}
+	want := "outer inner handler outer inner"
+	if got := strings.Join(calls, " "); got != want {
+		t.Errorf("The calls should be %q, but are: %q.", want, got)
+	}
+}
+
+func TestRemoveChannel(t *testing.T) {
+	b := New()
+	name := "testEventRemoveChannel"
+	c1 := make(chan Payload, 1)
+	c2 := make(chan Payload, 1)
+	b.AddChannel(name, c1)
+	b.AddChannel(name, c2)
+	if !b.RemoveChannel(name, c1) {
+		t.Error("RemoveChannel did not find a registered channel.")
+	}
+	if b.RemoveChannel(name, c1) {
+		t.Error("RemoveChannel found a channel that was already removed.")
+	}
+	b.PostAndWait(event.New(name))
+	if len(c1) != 0 || len(c2) != 1 {
+		t.Errorf("Only the remaining channel should receive the payload, but the lengths are: %v and %v.", len(c1), len(c2))
+	}
+}
+
+func TestRemoveBlockedChannel(t *testing.T) {
+	b := New()
+	name := "testEventRemoveBlocked"
+	c := make(chan Payload)
+	b.AddChannel(name, c)
+	done := make(chan error)
+	go func() { done <- b.PostAndWait(event.New(name)) }()
+	time.Sleep(10 * time.Millisecond)
+	b.RemoveChannel(name, c)
+	if err := <-done; err != nil {
+		t.Errorf("The post failed with message: %v.\n", err)
+	}
+}
+
+func TestCloseAbandonsBlockedChannel(t *testing.T) {
+	b := New()
+	name := "testEventCloseBlocked"
+	b.AddChannel(name, make(chan Payload))
+	b.Post(event.New(name))
+	for b.InFlight() == 0 {
+		runtime.Gosched()
+	}
+	b.Close()
+	done := make(chan struct{})
+	go func() {
+		b.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("The delivery blocked on a subscriber channel did not end when the bus closed.")
+	}
+}
+
+func TestIndependentBuses(t *testing.T) {
+	flags := log.Flags()
+	b1, b2 := New(), New()
+	defer b1.Close()
+	defer b2.Close()
+	if log.Flags() != flags {
+		t.Errorf("The standard logger flags should be %v, but are: %v.", flags, log.Flags())
+	}
+	var n1, n2 int32
+	b1.AddHandlers("testEventShared", func(p Payload) error {
+		atomic.AddInt32(&n1, 1)
+		return nil
+	})
+	b2.AddHandlers("testEventShared", func(p Payload) error {
+		atomic.AddInt32(&n2, 1)
+		return nil
+	})
+	b2.AddHandlers("testEventOther", h1)
+	for i := 0; i < 3; i++ {
+		if err := b1.PostAndWait(event.New("testEventShared")); err != nil {
+			t.Errorf("The post to the first bus failed with message: %v.\n", err)
+		}
+	}
+	if err := b2.PostAndWait(event.New("testEventShared")); err != nil {
+		t.Errorf("The post to the second bus failed with message: %v.\n", err)
+	}
+	if n := atomic.LoadInt32(&n1); n != 3 {
+		t.Errorf("The first bus handler count should be 3, but is: %v.", n)
+	}
+	if n := atomic.LoadInt32(&n2); n != 1 {
+		t.Errorf("The second bus handler count should be 1, but is: %v.", n)
+	}
+	if n := b1.HandlerCount("testEventOther"); n != 0 {
+		t.Errorf("The first bus should have no handlers for the other type, but has: %v.", n)
+	}
+	b1.Close()
+	if err := b2.PostAndWait(event.New("testEventShared")); err != nil {
+		t.Errorf("Closing the first bus should not affect the second, but the post failed: %v.", err)
+	}
+}
+
+func TestQueueLen(t *testing.T) {
+	b := New(WithPubChanBuffer(4), WithAsyncWorkers(1))
+	name := "testEventQueueLen"
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	b.AddHandlers(name, func(p Payload) error {
+		once.Do(func() { close(started) })
+		<-release
+		return nil
+	})
+	// Occupy the only worker and then the bus goroutine.
+	b.Post(event.New(name))
+	<-started
+	b.Post(event.New(name))
+	for b.QueueLen() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	b.Post(event.New(name))
+	b.Post(event.New(name))
+	if b.QueueLen() != 2 || b.QueueCap() != 4 {
+		t.Errorf("The queue should hold 2 of 4 payloads, but holds: %v of %v.", b.QueueLen(), b.QueueCap())
+	}
+	close(release)
+	b.Close()
+	u := New()
+	defer u.Close()
+	if u.QueueLen() != 0 || u.QueueCap() != 0 {
+		t.Errorf("An unbuffered queue should report 0 and 0, but reports: %v and %v.", u.QueueLen(), u.QueueCap())
+	}
+}
+
+func TestFlush(t *testing.T) {
+	b := New(WithPubChanBuffer(10), WithAsyncWorkers(2))
+	name := "testEventFlush"
+	release := make(chan struct{})
+	b.AddHandlers(name, func(p Payload) error {
+		<-release
+		return nil
+	})
+	b.Post(event.New(name))
+	b.Post(event.New(name))
+	if err := b.Flush(); err != nil {
+		t.Errorf("Flushing failed with message: %v.\n", err)
+	}
+	if n := b.Stats().QueueDepth; n != 0 {
+		t.Errorf("Nothing should be queued after a flush, but the depth is: %v.", n)
+	}
+	close(release)
+	b.Close()
+	if err := b.Flush(); !errors.Is(err, ErrBusClosed) {
+		t.Errorf("Flushing a closed bus should fail with ErrBusClosed, but the error is: %v.", err)
+	}
+}
+
+func TestWait(t *testing.T) {
+	b := New()
+	defer b.Close()
+	name := "testEventWait"
+	var n int32
+	b.AddHandlers(name, func(p Payload) error {
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&n, 1)
+		return nil
+	})
+	for i := 0; i < 5; i++ {
+		b.Post(event.New(name))
+	}
+	b.Wait()
+	if n := atomic.LoadInt32(&n); n != 5 {
+		t.Errorf("All 5 deliveries should be complete when Wait returns, but only %v are.", n)
+	}
+}
+
+func TestWaitWhilePosting(t *testing.T) {
+	b := New()
+	defer b.Close()
+	name := "testEventWaitWhilePosting"
+	var n int32
+	b.AddHandlers(name, func(p Payload) error {
+		atomic.AddInt32(&n, 1)
+		return nil
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 500; i++ {
+			b.Post(event.New(name))
+		}
+	}()
+	for i := 0; i < 500; i++ {
+		b.Wait()
+	}
+	<-done
+	b.Wait()
+	if n := atomic.LoadInt32(&n); n != 500 {
+		t.Errorf("All 500 deliveries should be complete when Wait returns, but only %v are.", n)
+	}
+}
+
+func TestNewChannel(t *testing.T) {
+	b := New()
+	defer b.Close()
+	name := "testEventNewChannel"
+	c := b.NewChannel(name, 0)
+	if cap(c) != 0 {
+		t.Errorf("A channel with buffer 0 should be unbuffered, but has capacity: %v.", cap(c))
+	}
+	done := make(chan error, 1)
+	go func() { done <- b.PostAndWait(event.New(name)) }()
+	select {
+	case <-done:
+		t.Error("The post should wait for the unbuffered channel to be read.")
+	case <-time.After(10 * time.Millisecond):
+	}
+	if p := <-c; p.Type() != name {
+		t.Errorf("The channel should receive a payload of type %v, but received: %v.", name, p.Type())
+	}
+	if err := <-done; err != nil {
+		t.Errorf("The post failed with message: %v.\n", err)
+	}
+	if buffered := b.NewChannel(name, 4); cap(buffered) != 4 || !b.RemoveChannel(name, buffered) {
+		t.Error("A buffered channel should be created and then removed.")
+	}
+	if !b.RemoveChannel(name, c) || b.ChannelCount(name) != 0 {
+		t.Error("The unbuffered channel should be removed.")
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	b := New()
+	defer b.Close()
+	name := "testEventSubscribe"
+	c, cancel := b.Subscribe(name)
+	for i := 0; i < 3; i++ {
+		if err := b.PostAndWait(event.New(name)); err != nil {
+			t.Errorf("The post failed with message: %v.\n", err)
+		}
+	}
+	cancel()
+	cancel()
+	n := 0
+	for range c {
+		n++
+	}
+	if n != 3 {
+		t.Errorf("3 payloads should be read, but %v were.", n)
+	}
+	if n := b.ChannelCount(name); n != 0 {
+		t.Errorf("The channel count should be 0 after cancelling, but is: %v.", n)
+	}
+	if err := b.PostAndWait(event.New(name)); err != nil {
+		t.Errorf("A post after cancelling failed with message: %v.\n", err)
+	}
+}
+
+func TestPruneClosedChannel(t *testing.T) {
+	b := New()
+	defer b.Close()
+	name := "testEventClosedChannel"
+	c := make(chan Payload, 1)
+	b.AddChannel(name, c)
+	b.AddChannel("testEvent.*", c)
+	close(c)
+	if err := b.PostAndWait(event.New(name)); err != nil {
+		t.Errorf("Posting to a closed channel failed with message: %v.\n", err)
+	}
+	if n := b.ChannelCount(name); n != 0 {
+		t.Errorf("The closed channel should be pruned, but the count is: %v.", n)
+	}
+	if err := b.PostAndWait(event.New(name)); err != nil {
+		t.Errorf("Posting after pruning failed with message: %v.\n", err)
+	}
+}
+
+func TestAddChannelContext(t *testing.T) {
+	b := New()
+	defer b.Close()
+	name := "testEventChannelContext"
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan Payload, 2)
+	b.AddChannelContext(ctx, name, c)
+	b.PostAndWait(event.New(name))
+	cancel()
+	for i := 0; i < 100 && b.ChannelCount(name) != 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if n := b.ChannelCount(name); n != 0 {
+		t.Fatalf("The channel should be removed once the context is done, but the count is: %v.", n)
+	}
+	b.PostAndWait(event.New(name))
+	if len(c) != 1 {
+		t.Errorf("Only the payload posted before cancelling should be sent, but %v were.", len(c))
+	}
+}
+
+func TestAddHandlersForTypes(t *testing.T) {
+	b := New()
+	defer b.Close()
+	types := []string{"user.created", "user.updated", "user.deleted"}
+	var n int32
+	s, err := b.AddHandlersForTypes(types, func(p Payload) error {
+		atomic.AddInt32(&n, 1)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Adding handlers for several types failed with message: %v.\n", err)
+	}
+	for _, typ := range types {
+		b.PostAndWait(event.New(typ))
+	}
+	if n := atomic.LoadInt32(&n); n != 3 {
+		t.Errorf("The handler should run once per type, but ran %v times.", n)
+	}
+	if n := b.Unsubscribe(s); n != 3 {
+		t.Errorf("Unsubscribe should remove 3 handlers, but removed: %v.", n)
+	}
+	if _, err := b.AddHandlersForTypes(nil, h1); err == nil {
+		t.Error("Adding handlers for no types did not fail as expected.")
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	b := New(WithHandlerTimeout(time.Millisecond))
+	defer b.Close()
+	if _, err := b.AddHandlers("testEventCodes"); !errors.Is(err, ErrNoHandlers) {
+		t.Errorf("Adding no handlers should fail with ErrNoHandlers, but returned: %v.", err)
+	}
+	err := b.Post(nil)
+	var code Code
+	if !errors.As(err, &code) || code != CodeEmptyPayload {
+		t.Errorf("Posting a nil payload should fail with CodeEmptyPayload, but returned: %v.", err)
+	}
+	if errors.Is(err, ErrBusClosed) {
+		t.Errorf("Posting a nil payload should not fail with ErrBusClosed, but returned: %v.", err)
+	}
+	name := "testEventCodes"
+	b.AddHandlers(name, func(p Payload) error {
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+	c := make(chan Payload)
+	b.AddChannelWithOptions(name, c, ChannelOptions{Overflow: OverflowError})
+	err = b.PostAndWait(event.New(name))
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, ErrChannelOverflow) {
+		t.Errorf("The post should fail with a timeout and an overflow, but returned: %v.", err)
+	}
+	if s := CodeBusClosed.String(); s != "bus closed" {
+		t.Errorf("The code description should be \"bus closed\", but is: %q.", s)
+	}
+}
+
+func TestSetTypeMode(t *testing.T) {
+	b := New()
+	defer b.Close()
+	failure := errors.New("failure")
+	b.AddHandlers("audit.login", failWith(failure))
+	block := make(chan struct{})
+	b.AddHandlers("testEventMode", func(p Payload) error {
+		<-block
+		return nil
+	})
+	b.SetTypeMode("audit.*", Synchronous)
+	b.SetTypeMode("testEventMode", Asynchronous)
+	if err := b.Post(event.New("audit.login")); !errors.Is(err, failure) {
+		t.Errorf("A synchronous type should return handler errors from Post, but returned: %v.", err)
+	}
+	if err := b.PostAndWait(event.New("testEventMode")); err != nil {
+		t.Errorf("An asynchronous type should not wait in PostAndWait, but returned: %v.", err)
+	}
+	close(block)
+	b.SetTypeMode("audit.*", 0)
+	if err := b.Post(event.New("audit.login")); err != nil {
+		t.Errorf("Post should be asynchronous once the override is removed, but returned: %v.", err)
+	}
+	if s := Asynchronous.String(); s != "asynchronously" {
+		t.Errorf("The mode should print as \"asynchronously\", but prints as: %q.", s)
+	}
+}
+
+func TestPauseAndResume(t *testing.T) {
+	b := New(WithAsyncWorkers(1))
+	defer b.Close()
+	name := "testEventPause"
+	var mu sync.Mutex
+	var got []int
+	b.AddHandlers(name, func(p Payload) error {
+		mu.Lock()
+		got = append(got, p.Data()["n"].(int))
+		mu.Unlock()
+		return nil
+	})
+	b.Pause()
+	for i := 0; i < 3; i++ {
+		e := event.New(name)
+		e.Data()["n"] = i
+		b.Post(e)
+	}
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	if len(got) != 0 {
+		t.Errorf("Nothing should be delivered while paused, but got: %v.", got)
+	}
+	mu.Unlock()
+	b.Resume()
+	b.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 3 || got[0] != 0 || got[1] != 1 || got[2] != 2 {
+		t.Errorf("The held payloads should be delivered in order on resume, but got: %v.", got)
+	}
+}
+
+func TestState(t *testing.T) {
+	b := New()
+	if s := b.State(); s != Running {
+		t.Errorf("A new bus should be running, but is: %v.", s)
+	}
+	b.Pause()
+	if s := b.State(); s != Paused {
+		t.Errorf("A paused bus should be paused, but is: %v.", s)
+	}
+	b.Resume()
+	b.Drain(time.Second)
+	if s := b.State(); s != Draining {
+		t.Errorf("A drained bus should be draining, but is: %v.", s)
+	}
+	b.Close()
+	if s := b.State(); s != Closed {
+		t.Errorf("A closed bus should be closed, but is: %v.", s)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	b := New(WithPubChanBuffer(10))
+	defer b.Close()
+	name := "testEventDrain"
+	var n int32
+	b.AddHandlers(name, func(p Payload) error {
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&n, 1)
+		return nil
+	})
+	for i := 0; i < 5; i++ {
+		b.Post(event.New(name))
+	}
+	if err := b.Drain(time.Second); err != nil {
+		t.Errorf("Draining failed with message: %v.\n", err)
+	}
+	if n := atomic.LoadInt32(&n); n != 5 {
+		t.Errorf("All 5 payloads should be delivered once drained, but %v were.", n)
+	}
+	if err := b.Post(event.New(name)); !errors.Is(err, ErrBusDraining) {
+		t.Errorf("A post to a drained bus should fail with ErrBusDraining, but returned: %v.", err)
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	b := New()
+	defer b.Close()
+	name := "testEventDrainTimeout"
+	release := make(chan struct{})
+	b.AddHandlers(name, func(p Payload) error {
+		<-release
+		return nil
+	})
+	b.Post(event.New(name))
+	if err := b.Drain(10 * time.Millisecond); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Draining a busy bus should time out, but returned: %v.", err)
+	}
+	close(release)
+}
+
+func TestChannelGracePeriod(t *testing.T) {
+	b := New(WithChannelGracePeriod(10 * time.Millisecond))
+	defer b.Close()
+	name := "testEventGrace"
+	stuck := make(chan Payload)
+	live := make(chan Payload, 1)
+	b.AddChannel(name, stuck)
+	b.AddChannel(name, live)
+	done := make(chan error, 1)
+	go func() { done <- b.PostAndWait(event.New(name)) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("The post failed with message: %v.\n", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The post should not hang on a subscriber channel without a reader.")
+	}
+	if len(live) != 1 {
+		t.Error("The channel after the stuck one did not receive the payload.")
+	}
+}
+
+func TestHandlerGroups(t *testing.T) {
+	b := New()
+	defer b.Close()
+	var running, peak int32
+	h := func(p Payload) error {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			max := atomic.LoadInt32(&peak)
+			if n <= max || atomic.CompareAndSwapInt32(&peak, max, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return nil
+	}
+	if _, err := b.AddHandlersInGroup("api", 2, "testEventGroupA", h); err != nil {
+		t.Errorf("Adding grouped handlers failed with message: %v.\n", err)
+	}
+	b.AddHandlersInGroup("api", 2, "testEventGroupB", h)
+	if _, err := b.AddHandlersInGroup("api", 3, "testEventGroupB", h); err == nil {
+		t.Error("Adding to a group with a different limit did not fail as expected.")
+	}
+	for i := 0; i < 10; i++ {
+		b.Post(event.New("testEventGroupA"))
+		b.Post(event.New("testEventGroupB"))
+	}
+	b.Wait()
+	if n := atomic.LoadInt32(&peak); n > 2 {
+		t.Errorf("No more than 2 grouped handlers should run at once, but %v did.", n)
+	}
+}
+
+func TestBlockedChannelFanOut(t *testing.T) {
+	b := New()
+	defer b.Close()
+	name := "testEventFanOut"
+	blocked := b.NewChannel(name, 0)
+	live := b.NewChannel(name, 0)
+	go b.PostAndWait(event.New(name))
+	select {
+	case <-live:
+	case <-time.After(time.Second):
+		t.Fatal("A blocked channel prevented another channel from receiving the payload.")
+	}
+	<-blocked
+	go func() {
+		for i := 0; i < 3; i++ {
+			e := event.New(name)
+			e.Data()["n"] = i
+			b.PostAndWait(e)
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		if n := (<-live).Data()["n"]; n != i {
+			t.Errorf("The channel should receive payload %v, but received: %v.", i, n)
+		}
+		<-blocked
+	}
+}
+
+func TestTopology(t *testing.T) {
+	b := New()
+	defer b.Close()
+	c := make(chan Payload, 1)
+	b.AddChannel("user.created", c)
+	b.AddChannel("user.*", c)
+	b.AddChannel("order.placed", make(chan Payload))
+	b.AddHandlers("user.created", h1, h2)
+	types := b.ChannelTypes(c)
+	if len(types) != 2 || types[0] != "user.*" || types[1] != "user.created" {
+		t.Errorf("The channel should be registered for 2 types, but is for: %v.", types)
+	}
+	topology := b.Topology()
+	if s := topology["user.created"]; s.Handlers != 2 || s.Channels != 1 {
+		t.Errorf(`"user.created" should have 2 handlers and 1 channel, but has: %+v.`, s)
+	}
+	if len(topology) != 3 {
+		t.Errorf("The topology should cover 3 types, but is: %v.", topology)
+	}
+}
+
+func TestChannelsFirst(t *testing.T) {
+	b := New(WithDeliveryOrder(ChannelsFirst))
+	defer b.Close()
+	name := "testEventChannelsFirst"
+	c := make(chan Payload, 1)
+	b.AddChannel(name, c)
+	var seen bool
+	b.AddHandlers(name, func(p Payload) error {
+		seen = len(c) == 1
+		return nil
+	})
+	results := b.Deliver(event.New(name))
+	if !seen {
+		t.Error("The channel should receive the payload before the handler runs.")
+	}
+	if len(results) != 2 || results[0].Channel != nil || results[1].Channel != c {
+		t.Errorf("The handler result should come before that of the channel, but the results are: %+v.", results)
+	}
+}
+
+func TestRoutingKey(t *testing.T) {
+	b := New(WithRoutingKeyFunc(func(p Payload) string {
+		tenant, _ := p.Data()["tenant"].(string)
+		if tenant == "" {
+			return ""
+		}
+		return "tenant." + tenant
+	}))
+	defer b.Close()
+	var a, other, plain int32
+	b.AddHandlers("tenant.a", func(p Payload) error {
+		atomic.AddInt32(&a, 1)
+		return nil
+	})
+	b.AddHandlers("tenant.b", func(p Payload) error {
+		atomic.AddInt32(&other, 1)
+		return nil
+	})
+	b.AddHandlers("message", func(p Payload) error {
+		atomic.AddInt32(&plain, 1)
+		return nil
+	})
+	e := event.New("message")
+	e.Data()["tenant"] = "a"
+	b.PostAndWait(e)
+	b.PostAndWait(event.New("message"))
+	if a, other, plain := atomic.LoadInt32(&a), atomic.LoadInt32(&other), atomic.LoadInt32(&plain); a != 1 || other != 0 || plain != 1 {
+		t.Errorf("Payloads should be routed by tenant, or by type without one, but the counts are: %v, %v and %v.", a, other, plain)
+	}
+}
+
+func TestPostAsync(t *testing.T) {
+	b := New()
+	name := "testEventPostAsync"
+	failure := errors.New("failure")
+	b.AddHandlers(name, h1, failWith(failure))
+	errc := b.PostAsync(event.New(name))
+	if err := <-errc; !errors.Is(err, failure) {
+		t.Errorf("The channel should receive the handler error, but received: %v.", err)
+	}
+	if _, ok := <-errc; ok {
+		t.Error("The channel should be closed after the outcome.")
+	}
+	b.Close()
+	errc = b.PostAsync(event.New(name))
+	if err := <-errc; !errors.Is(err, ErrBusClosed) {
+		t.Errorf("Posting to a closed bus should give ErrBusClosed, but gave: %v.", err)
+	}
+	if _, ok := <-errc; ok {
+		t.Error("The channel should be closed after a rejected post.")
+	}
+}
+
+func TestPostAndWaitTimeout(t *testing.T) {
+	b := New()
+	defer b.Close()
+	name := "testEventPostAndWaitTimeout"
+	release := make(chan struct{})
+	failure := errors.New("failure")
+	b.AddHandlers(name, func(p Payload) error {
+		<-release
+		return failure
+	})
+	start := time.Now()
+	err := b.PostAndWaitTimeout(event.New(name), 20*time.Millisecond)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("A stalled delivery should give ErrTimeout, but gave: %v.", err)
+	}
+	var errs MultiError
+	if errors.As(err, &errs) {
+		t.Error("The timeout should not be reported as a handler error.")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("The timeout should return promptly, but took %v.", elapsed)
+	}
+	close(release)
+	if err := b.PostAndWaitTimeout(event.New(name), time.Second); !errors.As(err, &errs) {
+		t.Errorf("A failing handler should give a MultiError, but gave: %v.", err)
+	}
+}
+
+func TestModeHandlers(t *testing.T) {
+	b := New()
+	defer b.Close()
+	name := "testEventModeHandlers"
+	modes := make(chan Mode, 2)
+	b.AddModeHandlers(name, func(p Payload, mode Mode) error {
+		modes <- mode
+		return nil
+	})
+	b.PostAndWait(event.New(name))
+	b.Post(event.New(name))
+	b.Wait()
+	if m := <-modes; m != Synchronous {
+		t.Errorf("PostAndWait should deliver synchronously, but the mode is: %v.", m)
+	}
+	if m := <-modes; m != Asynchronous {
+		t.Errorf("Post should deliver asynchronously, but the mode is: %v.", m)
+	}
+}
+
+func TestParallelHandlers(t *testing.T) {
+	b := New(WithParallelHandlers())
+	defer b.Close()
+	name := "testEventParallel"
+	var running sync.WaitGroup
+	running.Add(3)
+	together := func(err error) Handler {
+		return func(p Payload) error {
+			running.Done()
+			running.Wait()
+			return err
+		}
+	}
+	e1, e2 := errors.New("first failure"), errors.New("second failure")
+	b.AddHandlers(name, together(e1), together(nil), together(e2))
+	done := make(chan error, 1)
+	go func() { done <- b.PostAndWait(event.New(name)) }()
+	select {
+	case err := <-done:
+		errs, ok := err.(MultiError)
+		if !ok || len(errs) != 2 || errs[0] != e1 || errs[1] != e2 {
+			t.Errorf("The handler errors should be returned in handler order, but are: %v.", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The handlers of a payload did not run at once.")
+	}
+}
+
+func TestStopOnError(t *testing.T) {
+	b := New(WithStopOnError())
+	defer b.Close()
+	name := "testEventStopOnError"
+	veto := errors.New("veto")
+	var after int32
+	b.AddHandlers(name, h1, failWith(veto), func(p Payload) error {
+		atomic.AddInt32(&after, 1)
+		return nil
+	})
+	c := make(chan Payload, 1)
+	b.AddChannel(name, c)
+	if err := b.PostAndWait(event.New(name)); err != veto {
+		t.Errorf("The first handler error should be returned as is, but the error is: %v.", err)
+	}
+	if n := atomic.LoadInt32(&after); n != 0 || len(c) != 0 {
+		t.Errorf("Nothing should run after the veto, but the handler ran %v times and the channel holds %v.", n, len(c))
+	}
+	b.Post(event.New(name))
+	b.Wait()
+	if n := atomic.LoadInt32(&after); n != 1 {
+		t.Errorf("An asynchronous delivery should run every handler, but the last ran %v times.", n)
+	}
+}
+
+func TestInlineSyncDelivery(t *testing.T) {
+	b := New(WithInlineSyncDelivery())
+	name := "testEventInline"
+	failure := errors.New("failure")
+	b.AddHandlers(name, h1, failWith(failure))
+	if err := b.PostAndWait(event.New(name)); !errors.Is(err, failure) {
+		t.Errorf("The inline post should return the handler error, but returned: %v.", err)
+	}
+	if ts := b.Stats().Types[name]; ts.Posted != 1 || ts.Delivered != 1 {
+		t.Errorf("The inline post should be counted, but the counts are: %v and %v.", ts.Posted, ts.Delivered)
+	}
+	b.Close()
+	if err := b.PostAndWait(event.New(name)); !errors.Is(err, ErrBusClosed) {
+		t.Errorf("An inline post to a closed bus should fail with ErrBusClosed, but failed with: %v.", err)
+	}
+}
+
+func TestPostFromHandler(t *testing.T) {
+	b := New()
+	defer b.Close()
+	first, second := "testEventFirst", "testEventSecond"
+	var n int32
+	b.AddHandlers(first, func(p Payload) error {
+		return b.PostAndWait(event.New(second))
+	})
+	b.AddHandlers(second, func(p Payload) error {
+		atomic.AddInt32(&n, 1)
+		return nil
+	})
+	done := make(chan error, 1)
+	go func() { done <- b.PostAndWait(event.New(first)) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("The post failed with message: %v.\n", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("A handler posting synchronously deadlocked the bus.")
+	}
+	if n := atomic.LoadInt32(&n); n != 1 {
+		t.Errorf("The follow-up payload should be delivered once, but was delivered %v times.", n)
+	}
+}
+
+func TestPostFromAsyncHandler(t *testing.T) {
+	b := New(WithAsyncWorkers(1))
+	defer b.Close()
+	first, second := "testEventAsyncFirst", "testEventAsyncSecond"
+	var n int32
+	b.AddHandlers(first, func(p Payload) error {
+		for i := 0; i < 3; i++ {
+			go b.Post(event.New(second))
+		}
+		return nil
+	})
+	b.AddHandlers(second, func(p Payload) error {
+		atomic.AddInt32(&n, 1)
+		return nil
+	})
+	b.Post(event.New(first))
+	for i := 0; i < 100 && atomic.LoadInt32(&n) < 3; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&n); n != 3 {
+		t.Errorf("The posts of the only worker's handler should be delivered, but %v of 3 were.", n)
+	}
+}
+
+func TestConcurrentPostAndSubscribe(t *testing.T) {
+	b := New()
+	name := "testEventConcurrent"
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s, _ := b.AddHandlers(name, h1)
+			b.AddChannel(name, make(chan Payload, 10))
+			b.Unsubscribe(s)
+		}()
+		go func() {
+			defer wg.Done()
+			b.Post(event.New(name))
+		}()
+	}
+	wg.Wait()
+}
+
+func TestClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+	b := New()
+	if err := b.Close(); err != nil {
+		t.Errorf("Close failed with message: %v.\n", err)
+	}
+	settled := false
+	for i := 0; i < 100 && !settled; i++ {
+		settled = runtime.NumGoroutine() <= before
+		time.Sleep(time.Millisecond)
+	}
+	if !settled {
+		t.Errorf("The goroutine count should be at most %v, but is: %v.", before, runtime.NumGoroutine())
+	}
+	if err := b.Post(event.New("testEventClosed")); !errors.Is(err, ErrBusClosed) {
+		t.Errorf("Post on a closed bus should return ErrBusClosed, but returned: %v.", err)
+	}
+	if err := b.PostAndWait(event.New("testEventClosed")); err == nil {
+		t.Error("PostAndWait on a closed bus did not return an error as expected.")
+	}
+	if err := b.Close(); err != nil {
+		t.Errorf("A second Close failed with message: %v.\n", err)
+	}
+}
+
+func TestHandlerPanic(t *testing.T) {
+	b := New()
+	name := "testEventPanic"
+	ran := false
+	b.AddHandlers(name, func(p Payload) error {
+		panic("bad handler")
+	})
+	b.AddHandlers(name, func(p Payload) error {
+		ran = true
+		return nil
+	})
+	if err := b.PostAndWait(event.New(name)); err != nil {
+		t.Errorf("A panic should be swallowed by default, but the post gave: %v.", err)
+	}
+	if !ran {
+		t.Error("The handler registered after a panicking handler did not run.")
+	}
+}
+
+func TestPanicPolicy(t *testing.T) {
+	name := "testEventPanicPolicy"
+	bad := func(p Payload) error { panic("bad handler") }
+	b := New(WithPanicPolicy(PanicAsError), WithLogLevel(LogOff))
+	b.AddHandlers(name, bad)
+	if err := b.PostAndWait(event.New(name)); !errors.Is(err, ErrHandlerPanic) {
+		t.Errorf("Under PanicAsError the post should give ErrHandlerPanic, but gave: %v.", err)
+	}
+	b.Close()
+	b = New(WithPanicPolicy(PanicRepanic), WithLogLevel(LogOff))
+	defer b.Close()
+	b.AddCommandHandler(name, bad)
+	defer func() {
+		if v := recover(); v != "bad handler" {
+			t.Errorf("Under PanicRepanic the panic should reach the caller, but recovered: %v.", v)
+		}
+	}()
+	b.Dispatch(event.New(name))
+	t.Error("Under PanicRepanic the dispatch should not return.")
+}
+
+func TestPostAndWaitErrors(t *testing.T) {
+	b := New()
+	name := "testEventErrors"
+	e1 := errors.New("first failure")
+	e2 := errors.New("second failure")
+	b.AddHandlers(name, h1, failWith(e1), h2, failWith(e2))
+	err := b.PostAndWait(event.New(name))
+	var errs MultiError
+	if !errors.As(err, &errs) {
+		t.Fatalf("PostAndWait should return a MultiError, but returned: %v.", err)
+	}
+	if len(errs) != 2 || errs[0] != e1 || errs[1] != e2 {
+		t.Errorf("PostAndWait returned the wrong errors: %v.", errs)
+	}
+	if !errors.Is(err, e2) {
+		t.Error("The aggregated error does not match a handler error.")
+	}
+	b.RemoveHandlers(name)
+	b.AddHandlers(name, h1)
+	if err := b.PostAndWait(event.New(name)); err != nil {
+		t.Errorf("PostAndWait should have succeeded, but returned: %v.", err)
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	b := New()
+	name := "testEventErrorHandler"
+	e1 := errors.New("async failure")
+	reported := make(chan error, 1)
+	b.SetErrorHandler(func(p Payload, err error) {
+		reported <- err
+	})
+	b.AddHandlers(name, failWith(e1))
+	b.Post(event.New(name))
+	if err := <-reported; !errors.Is(err, e1) {
+		t.Errorf("The error handler received the wrong error: %v.", err)
+	}
+}
+
+func TestPostWithContext(t *testing.T) {
+	b := New()
+	name := "testEventContext"
+	type key struct{}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "value"))
+	defer cancel()
+	var seen interface{}
+	ran := false
+	b.AddContextHandlers(name, func(ctx context.Context, p Payload) error {
+		seen = ctx.Value(key{})
+		cancel()
+		return nil
+	})
+	b.AddHandlers(name, func(p Payload) error {
+		ran = true
+		return nil
+	})
+	if err := b.PostWithContext(ctx, event.New(name)); err != context.Canceled {
+		t.Errorf("PostWithContext should return context.Canceled, but returned: %v.", err)
+	}
+	b.Close()
+	if seen != "value" {
+		t.Errorf("The context handler did not receive the posting context, saw: %v.", seen)
+	}
+	if ran {
+		t.Error("A handler ran after the posting context was cancelled.")
+	}
+}
+
+func TestWildcardHandlers(t *testing.T) {
+	b := New()
+	var calls []string
+	record := func(label string) Handler {
+		return func(p Payload) error {
+			calls = append(calls, label+":"+p.Type())
+			return nil
+		}
+	}
+	b.AddHandlers("user.created", record("exact"))
+	b.AddHandlers("user.*", record("user"))
+	b.AddHandlers("user.address.*", record("address"))
+	b.AddHandlers("order.*", record("order"))
+	b.PostAndWait(event.New("user.created"))
+	b.PostAndWait(event.New("user.address.changed"))
+	b.PostAndWait(event.New("user"))
+	want := "exact:user.created user:user.created address:user.address.changed user:user.address.changed"
+	if got := strings.Join(calls, " "); got != want {
+		t.Errorf("The wildcard deliveries should be %q, but are: %q.", want, got)
+	}
+}
+
+func TestWildcardNoDuplicates(t *testing.T) {
+	b := New()
+	name := "user.updated"
+	count := 0
+	counter := func(p Payload) error {
+		count++
+		return nil
+	}
+	c := make(chan Payload, 2)
+	b.AddHandlers(name, counter)
+	b.AddHandlers("user.*", counter)
+	b.AddChannel(name, c)
+	b.AddChannel("user.*", c)
+	b.PostAndWait(event.New(name))
+	if count != 1 {
+		t.Errorf("The handler should have run once, but ran: %v.", count)
+	}
+	if n := len(c); n != 1 {
+		t.Errorf("The channel should hold 1 payload, but holds: %v.", n)
+	}
+}
+
+func TestCounts(t *testing.T) {
+	b := New()
+	b.AddHandlers("b.type", h1, h2)
+	b.AddChannel("a.type", make(chan Payload))
+	b.AddChannel("b.type", make(chan Payload))
+	if n := b.HandlerCount("b.type"); n != 2 {
+		t.Errorf("The handler count should be 2, but is: %v.", n)
+	}
+	if n := b.ChannelCount("a.type"); n != 1 {
+		t.Errorf("The channel count should be 1, but is: %v.", n)
+	}
+	if n := b.HandlerCount("c.type"); n != 0 {
+		t.Errorf("The handler count of an unknown type should be 0, but is: %v.", n)
+	}
+	if types := strings.Join(b.Types(), " "); types != "a.type b.type" {
+		t.Errorf("The types should be \"a.type b.type\", but are: %q.", types)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	b := New(WithPubChanBuffer(4), WithAsyncWorkers(2))
+	if n := cap(b.pubchan); n != 4 {
+		t.Errorf("The posting channel capacity should be 4, but is: %v.", n)
+	}
+	if b.workers != 2 {
+		t.Errorf("The number of async workers should be 2, but is: %v.", b.workers)
+	}
+	b.Close()
+	b = New()
+	if cap(b.pubchan) != 0 || b.workers != runtime.NumCPU() {
+		t.Error("New without options should create an unbuffered bus with a worker per CPU.")
+	}
+	b.Close()
+}
+
+func TestOrderedAsync(t *testing.T) {
+	b := New(WithOrderedAsync())
+	var mu sync.Mutex
+	var got []int
+	done := make(chan struct{})
+	b.AddHandlers("account.event", func(p Payload) error {
+		time.Sleep(time.Duration(5-p.Data()["n"].(int)%5) * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		if got = append(got, p.Data()["n"].(int)); len(got) == 20 {
+			close(done)
+		}
+		return nil
+	})
+	for i := 0; i < 20; i++ {
+		e := event.New("account.event")
+		e.Data()["n"] = i
+		b.Post(e)
+	}
+	<-done
+	for i, n := range got {
+		if n != i {
+			t.Fatalf("The payloads were delivered out of order: %v.", got)
+		}
+	}
+}
+
+func TestInFlight(t *testing.T) {
+	b := New(WithAsyncWorkers(1))
+	name := "testEventInFlight"
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	b.AddHandlers(name, func(p Payload) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	})
+	b.Post(event.New(name))
+	b.Post(event.New(name))
+	<-started
+	if n := b.InFlight(); n != 1 {
+		t.Errorf("With one worker 1 delivery should be in flight, but %v are.", n)
+	}
+	close(release)
+	<-started
+	b.Close()
+}
+
+func TestNonBlockingPosts(t *testing.T) {
+	b := New(WithPubChanBuffer(1), WithNonBlockingPosts(), WithAsyncWorkers(1))
+	name := "testEventFull"
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	b.AddHandlers(name, func(p Payload) error {
+		once.Do(func() { close(started) })
+		<-release
+		return nil
+	})
+	// Occupy the only worker and then the bus goroutine.
+	b.Post(event.New(name))
+	<-started
+	b.Post(event.New(name))
+	for len(b.pubchan) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := b.Post(event.New(name)); err != nil {
+		t.Errorf("The post into the buffer failed with message: %v.\n", err)
+	}
+	err := b.Post(event.New(name))
+	if !errors.Is(err, ErrBusFull) || errors.Is(err, ErrBusClosed) {
+		t.Errorf("Posting to a full bus should return ErrBusFull, but returned: %v.", err)
+	}
+	close(release)
+	b.Close()
+}
+
+func TestPayloadValidation(t *testing.T) {
+	missing := errors.New("missing id")
+	b := New(WithPayloadValidator(func(p Payload) error {
+		if _, ok := p.Data()["id"]; !ok {
+			return missing
+		}
+		return nil
+	}))
+	if err := b.Post(nil); err == nil {
+		t.Error("Posting a nil payload did not fail as expected.")
+	}
+	if err := b.PostAndWait(event.New("")); err == nil {
+		t.Error("Posting a payload with an empty type did not fail as expected.")
+	}
+	if err := b.PostAndWait(event.New("testEventInvalid")); !errors.Is(err, missing) {
+		t.Errorf("The validator error should be returned, but the post returned: %v.", err)
+	}
+	e := event.New("testEventValid")
+	e.Data()["id"] = 1
+	if err := b.PostAndWait(e); err != nil {
+		t.Errorf("The valid post failed with message: %v.\n", err)
+	}
+}
+
+func TestPayloadEnricher(t *testing.T) {
+	var seq, calls int32
+	b := New(WithPayloadEnricher(func(p Payload) Payload {
+		atomic.AddInt32(&calls, 1)
+		if p.Type() == "testEventDropped" {
+			return nil
+		}
+		p.Data()["seq"] = atomic.AddInt32(&seq, 1)
+		return p
+	}))
+	defer b.Close()
+	name := "testEventEnriched"
+	var got []interface{}
+	record := func(p Payload) error {
+		got = append(got, p.Data()["seq"])
+		return nil
+	}
+	b.AddHandlers(name, record, record)
+	c := make(chan Payload, 1)
+	b.AddChannel(name, c)
+	if err := b.PostAndWait(event.New(name)); err != nil {
+		t.Errorf("The post failed with message: %v.\n", err)
+	}
+	if len(got) != 2 || got[0] != int32(1) || got[1] != int32(1) {
+		t.Errorf("Both handlers should see sequence number 1, but saw: %v.", got)
+	}
+	if seq := (<-c).Data()["seq"]; seq != int32(1) {
+		t.Errorf("The channel should see sequence number 1, but saw: %v.", seq)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("The enricher should run once per post, but ran %v times.", n)
+	}
+	if err := b.PostAndWait(event.New("testEventDropped")); err == nil {
+		t.Error("A post the enricher returns nil for did not fail as expected.")
+	}
+}
+
+func TestPostBatch(t *testing.T) {
+	b := New(WithOrderedAsync())
+	defer b.Close()
+	name := "testEventBatch"
+	var mu sync.Mutex
+	var got []interface{}
+	b.AddHandlers(name, func(p Payload) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, p.Data()["n"])
+		return nil
+	})
+	var ps []Payload
+	for i := 0; i < 10; i++ {
+		e := event.New(name)
+		e.Data()["n"] = i
+		ps = append(ps, e)
+	}
+	if err := b.PostBatch(ps...); err != nil {
+		t.Errorf("The batch post failed with message: %v.\n", err)
+	}
+	b.Wait()
+	mu.Lock()
+	for i, n := range got {
+		if n != i {
+			t.Errorf("The payload at %v should be number %v, but is: %v.", i, i, n)
+		}
+	}
+	if len(got) != 10 {
+		t.Errorf("10 payloads should be delivered, but %v are.", len(got))
+	}
+	mu.Unlock()
+	err := b.PostBatch(event.New(name), nil, event.New(""))
+	var errs MultiError
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Errorf("The batch post should fail for 2 payloads, but returned: %v.", err)
+	}
+	b.Close()
+	if err := b.PostBatch(event.New(name)); !errors.Is(err, ErrBusClosed) {
+		t.Errorf("A batch posted to a closed bus should fail, but returned: %v.", err)
+	}
+}
+
+func TestTryPost(t *testing.T) {
+	b := New(WithPubChanBuffer(1), WithAsyncWorkers(1))
+	defer b.Close()
+	name := "testEventTryPost"
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	b.AddHandlers(name, func(p Payload) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	})
+	if !b.TryPost(event.New(name)) {
+		t.Error("The first post should be accepted.")
+	}
+	<-started
+	// Once the only worker is busy and the bus goroutine waits for it,
+	// one more payload fits in the buffer and the next is rejected.
+	for b.TryPost(event.New(name)) {
+	}
+	if n := b.Stats().QueueDepth; n != 1 {
+		t.Errorf("The queue depth should be 1, but is: %v.", n)
+	}
+	if b.TryPost(nil) {
+		t.Error("A nil payload should not be accepted.")
+	}
+	close(release)
+}
+
+func TestCloseRejectsBuffered(t *testing.T) {
+	b := New(WithPubChanBuffer(4), WithAsyncWorkers(1))
+	name := "testEventBuffered"
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	b.AddHandlers(name, func(p Payload) error {
+		once.Do(func() { close(started) })
+		<-release
+		return nil
+	})
+	// The first post occupies the only worker and the second the bus
+	// goroutine, waiting for the worker, so the others stay in the
+	// buffer until Close rejects them.
+	b.Post(event.New(name))
+	<-started
+	b.Post(event.New(name))
+	for len(b.pubchan) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	errc := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { errc <- b.PostAndWait(event.New(name)) }()
+	}
+	for len(b.pubchan) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+	b.Close()
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err == nil {
+			t.Error("A buffered post was not rejected by Close.")
+		}
+	}
+}
+
 func TestRunHandlersWithNoData(t *testing.T) {
 	b := New()
 	name := "testEvent"
@@ -104,7 +1827,85 @@ func h2(p Payload) error { return nil }
 func h3(p Payload) error { return nil }
 func h4(p Payload) error { return nil }
 
+func failWith(err error) Handler {
+	return func(p Payload) error { return err }
+}
+
+// fill occupies the only worker of a bus created with
+// WithPubChanBuffer(1) and WithAsyncWorkers(1) and then its posting
+// channel, so that the next TryPost is refused, and returns a function
+// that sets the bus running again.  The payloads filling the bus have
+// distinct data, so that they are not dropped as duplicates.
+func fill(b *Bus) func() {
+	name := "testEventFill"
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	b.AddHandlers(name, func(p Payload) error {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		return nil
+	})
+	post := func(n int) bool {
+		e := event.New(name)
+		e.Data()["fill"] = n
+		return b.TryPost(e)
+	}
+	post(0)
+	<-started
+	for n := 1; post(n); n++ {
+	}
+	return func() { close(release) }
+}
+
 func handler(p Payload) error {
 	fmt.Printf("Payload data is: %v.\n", p.Data()["count"])
 	return nil
 }
+
+func benchmarkPostAndWait(b *testing.B, opts ...Option) {
+	bus := New(opts...)
+	defer bus.Close()
+	name := "benchmarkEvent"
+	bus.AddHandlers(name, h1)
+	p := event.New(name)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bus.PostAndWait(p)
+	}
+}
+
+func BenchmarkPostAndWait(b *testing.B) {
+	benchmarkPostAndWait(b)
+}
+
+func BenchmarkPostAndWaitInline(b *testing.B) {
+	benchmarkPostAndWait(b, WithInlineSyncDelivery())
+}
+
+func benchmarkSlowHandlers(b *testing.B, opts ...Option) {
+	bus := New(opts...)
+	defer bus.Close()
+	name := "benchmarkEvent"
+	for i := 0; i < 4; i++ {
+		bus.AddHandlers(name, func(p Payload) error {
+			time.Sleep(time.Millisecond)
+			return nil
+		})
+	}
+	p := event.New(name)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bus.PostAndWait(p)
+	}
+}
+
+func BenchmarkSlowHandlers(b *testing.B) {
+	benchmarkSlowHandlers(b)
+}
+
+func BenchmarkSlowHandlersParallel(b *testing.B) {
+	benchmarkSlowHandlers(b, WithParallelHandlers())
+}
@@ -0,0 +1,70 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import "time"
+
+// A Clock tells the bus the time and makes its timers, so that tests
+// can replace the real clock with a FakeClock and advance time at will
+// to exercise delayed and recurring posts, dedup windows, rate limits,
+// circuit breaker cooldowns and timeouts without sleeping.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+	AfterFunc(d time.Duration, f func()) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// A Timer is a timer made by a Clock.  C is nil for the timers made by
+// AfterFunc.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// A Ticker is a ticker made by a Clock.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// WithClock makes the bus use c for all its time keeping: the times of
+// posts, statistics and errors as well as every timer.  The default is
+// the real clock of the time package.  Errors returned by functions not
+// tied to a bus, such as MarshalPayload, use the real clock.
+func WithClock(c Clock) Option {
+	return func(b *Bus) {
+		b.clock = c
+	}
+}
+
+// realClock is the Clock of the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTimer(d time.Duration) Timer         { return realTimer{time.NewTimer(d)} }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+// realTimer is a Timer of the time package.
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time { return t.t.C }
+func (t realTimer) Stop() bool          { return t.t.Stop() }
+
+// realTicker is a Ticker of the time package.
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
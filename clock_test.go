@@ -0,0 +1,78 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pajato/event"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFakeClock(start)
+	timer := c.NewTimer(2 * time.Second)
+	ticker := c.NewTicker(time.Second)
+	fired := 0
+	c.AfterFunc(3*time.Second, func() { fired++ })
+	c.Advance(time.Second)
+	if len(timer.C()) != 0 || len(ticker.C()) != 1 {
+		t.Error("Only the ticker should have fired after a second.")
+	}
+	<-ticker.C()
+	c.Advance(2 * time.Second)
+	if got := <-timer.C(); !got.Equal(start.Add(2 * time.Second)) {
+		t.Errorf("The timer should fire at its deadline, but fired at: %v.", got)
+	}
+	if fired != 1 || !c.Now().Equal(start.Add(3*time.Second)) {
+		t.Errorf("The function should have run once by %v, but ran %v times by %v.", start.Add(3*time.Second), fired, c.Now())
+	}
+	ticker.Stop()
+	if timer.Stop() || c.Timers() != 0 {
+		t.Errorf("No timer should be left waiting, but %v are.", c.Timers())
+	}
+}
+
+func TestWithClock(t *testing.T) {
+	c := NewFakeClock(time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC))
+	b := New(WithClock(c), WithCircuitBreaker(1, time.Minute), WithLogLevel(LogOff))
+	name := "testEventClock"
+	runs := 0
+	b.AddHandlers(name, func(p Payload) error {
+		runs++
+		return errors.New("failure")
+	})
+	b.PostAndWait(event.New(name))
+	b.PostAndWait(event.New(name))
+	if runs != 1 {
+		t.Errorf("The tripped handler should be skipped during its cooldown, but ran %v times.", runs)
+	}
+	c.Advance(time.Minute)
+	b.PostAndWait(event.New(name))
+	if runs != 2 {
+		t.Errorf("The handler should be probed once the cooldown has passed, but ran %v times.", runs)
+	}
+	ch := make(chan Payload, 1)
+	b.AddChannel("testEventLater", ch)
+	b.PostAfter(time.Hour, event.New("testEventLater"))
+	c.Advance(time.Hour - time.Second)
+	b.Wait()
+	if len(ch) != 0 {
+		t.Error("The scheduled post should not be made before its time.")
+	}
+	c.Advance(time.Second)
+	b.Wait()
+	if len(ch) != 1 {
+		t.Error("The scheduled post should be made once its time has come.")
+	}
+	b.Close()
+	err := b.Post(event.New(name))
+	var be *busError
+	if !errors.As(err, &be) || !be.When.Equal(c.Now()) {
+		t.Errorf("The error should carry the fake time %v, but is: %#v.", c.Now(), err)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A coalescer holds the payload merged from a burst of posts of one
+// type until the burst settles.  Each post starts a new generation so
+// that the timer of an earlier post cannot deliver the burst early.
+type coalescer struct {
+	mu      sync.Mutex
+	window  time.Duration
+	merge   func(prev, next Payload) Payload
+	pending Payload
+	gen     uint64
+	timer   Timer
+}
+
+// WithCoalesce makes the bus coalesce the bursts of payloads of the
+// given type posted with Post: rather than deliver each payload, the
+// bus holds one, merging each payload posted after it into it with
+// merge, and delivers the result asynchronously once window has passed
+// without a post, a trailing debounce, whatever the mode of the type.
+// A nil merge keeps the latest payload.  It suits update events whose
+// last state is all that matters.  The other kinds of post are not
+// coalesced.  Wait waits for a pending burst and Drain delivers it at
+// once, as it does a burst settling while the bus drains, while Close
+// drops it.  The option may be given once for each coalesced type.
+func WithCoalesce(typ string, window time.Duration, merge func(prev, next Payload) Payload) Option {
+	return func(b *Bus) {
+		if b.coalescers == nil {
+			b.coalescers = make(map[string]*coalescer)
+		}
+		b.coalescers[typ] = &coalescer{window: window, merge: merge}
+	}
+}
+
+// coalesce merges a posted payload into the pending burst of its type
+// and reports whether it did, which it does not for a type that is not
+// coalesced or for a closed bus.
+func (b *Bus) coalesce(p Payload) bool {
+	c := b.coalescers[p.Type()]
+	if c == nil {
+		return false
+	}
+	b.gate.RLock()
+	defer b.gate.RUnlock()
+	if b.admit() != nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.pending == nil:
+		c.pending = p
+		b.pending.Add(1)
+	case c.merge == nil:
+		c.pending = p
+	default:
+		c.pending = c.merge(c.pending, p)
+	}
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.gen++
+	gen := c.gen
+	c.timer = b.clock.AfterFunc(c.window, func() { b.settleBurst(c, gen) })
+	return true
+}
+
+// settleBurst delivers the pending burst of a coalescer unless a later
+// post started another generation.
+func (b *Bus) settleBurst(c *coalescer, gen uint64) {
+	c.mu.Lock()
+	p := c.pending
+	if p == nil || c.gen != gen {
+		c.mu.Unlock()
+		return
+	}
+	c.pending = nil
+	c.mu.Unlock()
+	b.deliverBurst(p)
+}
+
+// flushBursts delivers the pending burst of every coalescer at once,
+// as the bus starts draining.
+func (b *Bus) flushBursts() {
+	for _, c := range b.coalescers {
+		if p := c.take(); p != nil {
+			go b.deliverBurst(p)
+		}
+	}
+}
+
+// deliverBurst queues the payload merged from a burst for asynchronous
+// delivery, even once the bus is draining, since the burst started
+// before, and then releases the pending delivery the burst held.
+func (b *Bus) deliverBurst(p Payload) {
+	defer b.pending.Done()
+	if b.logs(LogDebug) {
+		b.logger.Printf("Posting coalesced payload of type: %v.\n", p.Type())
+	}
+	r := rider{payload: p, mode: Asynchronous, ctx: context.Background(), burst: true}
+	if err := b.enqueue(r, true); err != nil {
+		if b.logs(LogError) {
+			b.logger.Printf("Coalesced post of payload with type: %v failed: %v.\n", p.Type(), err)
+		}
+	}
+}
+
+// stopCoalescing drops the pending bursts of every coalescer.
+func (b *Bus) stopCoalescing() {
+	for typ, c := range b.coalescers {
+		if c.take() != nil {
+			b.pending.Done()
+			if b.logs(LogInfo) {
+				b.logger.Printf("Dropping the coalesced payload of type: %v pending at close.\n", typ)
+			}
+		}
+	}
+}
+
+// take removes and returns the pending burst, if any, stopping its
+// timer.
+func (c *coalescer) take() Payload {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := c.pending
+	if p != nil {
+		c.timer.Stop()
+		c.pending = nil
+		c.gen++
+	}
+	return p
+}
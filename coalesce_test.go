@@ -0,0 +1,85 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalesce(t *testing.T) {
+	name := "testEventCoalesce"
+	c := NewFakeClock(time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC))
+	merge := func(prev, next Payload) Payload {
+		return NewPayload(name, map[string]interface{}{"count": prev.Data()["count"].(int) + next.Data()["count"].(int)}, nil)
+	}
+	b := New(WithClock(c), WithCoalesce(name, time.Second, merge))
+	defer b.Close()
+	var deliveries, total int32
+	b.AddHandlers(name, func(p Payload) error {
+		atomic.AddInt32(&deliveries, 1)
+		atomic.AddInt32(&total, int32(p.Data()["count"].(int)))
+		return nil
+	})
+	for i := 0; i < 3; i++ {
+		if err := b.Post(NewPayload(name, map[string]interface{}{"count": 1}, nil)); err != nil {
+			t.Fatalf("The coalesced post failed with message: %v.\n", err)
+		}
+		c.Advance(time.Second / 2)
+	}
+	if n := atomic.LoadInt32(&deliveries); n != 0 {
+		t.Errorf("Nothing should be delivered within the window, but %v payloads were.", n)
+	}
+	c.Advance(time.Second / 2)
+	b.Wait()
+	if n, sum := atomic.LoadInt32(&deliveries), atomic.LoadInt32(&total); n != 1 || sum != 3 {
+		t.Errorf("The burst should be delivered once with a count of 3, but was delivered %v times with %v.", n, sum)
+	}
+	b.Post(NewPayload(name, map[string]interface{}{"count": 1}, nil))
+	if err := b.Drain(time.Second); err != nil {
+		t.Errorf("Draining should deliver the pending burst, but failed with message: %v.\n", err)
+	}
+	if n := atomic.LoadInt32(&deliveries); n != 2 {
+		t.Errorf("Draining should deliver the pending burst, but %v payloads were delivered.", n)
+	}
+}
+
+func TestCoalesceWhileDraining(t *testing.T) {
+	name := "testEventCoalesceDraining"
+	c := NewFakeClock(time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC))
+	b := New(WithClock(c), WithCoalesce(name, time.Second, nil))
+	defer b.Close()
+	b.SetTypeMode(name, Synchronous)
+	release := make(chan struct{})
+	var deliveries int32
+	b.AddHandlers(name, func(p Payload) error {
+		<-release
+		atomic.AddInt32(&deliveries, 1)
+		return nil
+	})
+	b.Post(NewPayload(name, nil, nil))
+
+	// A burst settling once the bus is draining is still delivered,
+	// and asynchronously although the type is synchronous.
+	b.gate.Lock()
+	b.gate.draining = true
+	b.gate.Unlock()
+	settled := make(chan struct{})
+	go func() {
+		c.Advance(time.Second)
+		close(settled)
+	}()
+	select {
+	case <-settled:
+	case <-time.After(time.Second):
+		t.Fatal("The burst should be delivered asynchronously, but its timer is blocked.")
+	}
+	close(release)
+	b.Wait()
+	if n := atomic.LoadInt32(&deliveries); n != 1 {
+		t.Errorf("The burst should be delivered once, but was delivered %v times.", n)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import "fmt"
+
+// AddCommandHandler will register the handler of a command type.  A
+// command, unlike an event, has exactly one handler, which must
+// succeed, so registering a second handler for a command type is an
+// error.  Commands are sent with Dispatch and are separate from the
+// handlers and channels that payloads posted to the bus reach.
+func (b *Bus) AddCommandHandler(typ string, h Handler) error {
+	if h == nil {
+		message := "Argument error: a command handler must be provided."
+		return &busError{b.clock.Now(), message, CodeInvalidArgument, nil}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.checkType(typ); err != nil {
+		return err
+	}
+	if _, ok := b.commands[typ]; ok {
+		message := fmt.Sprintf("Argument error: command type: %v already has a handler.", typ)
+		return &busError{b.clock.Now(), message, CodeInvalidArgument, nil}
+	}
+	b.commands[typ] = h
+	return nil
+}
+
+// RemoveCommandHandler will remove the handler of a command type and
+// report whether there was one.
+func (b *Bus) RemoveCommandHandler(typ string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.commands[typ]
+	delete(b.commands, typ)
+	return ok
+}
+
+// Dispatch will invoke the handler of a command on the calling
+// goroutine and return its error as is.  The command passes through
+// the enricher, validator and middleware of the bus like a post, and
+// its handler is subject to the handler timeout.  Dispatching a
+// command without a handler returns an ErrNoCommandHandler error and
+// dispatching to a closed or draining bus an ErrBusClosed or
+// ErrBusDraining error.
+func (b *Bus) Dispatch(p Payload) error {
+	p, err := b.prepare(p)
+	if err != nil {
+		return err
+	}
+	b.gate.RLock()
+	err = b.admit()
+	b.gate.RUnlock()
+	if err != nil {
+		return err
+	}
+	b.mu.RLock()
+	h, ok := b.commands[p.Type()]
+	middleware := b.cfg.middleware
+	b.mu.RUnlock()
+	if !ok {
+		message := fmt.Sprintf("Command error: no handler for command with type: %v.", p.Type())
+		return &busError{b.clock.Now(), message, CodeNoCommandHandler, nil}
+	}
+	if b.logs(LogDebug) {
+		b.logger.Printf("Dispatching command of type: %v.\n", p.Type())
+	}
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return b.call(h, p)
+}
@@ -0,0 +1,41 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pajato/event"
+)
+
+func TestDispatch(t *testing.T) {
+	b := New()
+	defer b.Close()
+	name := "command.charge"
+	failure := errors.New("declined")
+	if err := b.AddCommandHandler(name, failWith(failure)); err != nil {
+		t.Fatalf("Registering the command handler failed with message: %v.\n", err)
+	}
+	if err := b.AddCommandHandler(name, h1); err == nil {
+		t.Error("Registering a second command handler did not fail as expected.")
+	}
+	if err := b.Dispatch(event.New(name)); err != failure {
+		t.Errorf("The handler error should be returned as is, but the error is: %v.", err)
+	}
+	if err := b.Dispatch(event.New("command.unknown")); !errors.Is(err, ErrNoCommandHandler) {
+		t.Errorf("A command without a handler should fail with ErrNoCommandHandler, but the error is: %v.", err)
+	}
+	if !b.RemoveCommandHandler(name) || b.RemoveCommandHandler(name) {
+		t.Error("RemoveCommandHandler should find the handler exactly once.")
+	}
+	b.AddCommandHandler(name, h1)
+	b.gate.Lock()
+	b.gate.draining = true
+	b.gate.Unlock()
+	if err := b.Dispatch(event.New(name)); !errors.Is(err, ErrBusDraining) {
+		t.Errorf("Dispatching to a draining bus should fail with ErrBusDraining, but the error is: %v.", err)
+	}
+}
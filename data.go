@@ -0,0 +1,135 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import (
+	"encoding/json"
+	"math"
+)
+
+// GetInt returns the value stored under key in the data of p as an int.
+// Any integer type converts as long as the value fits, and so does a
+// whole floating point number or json.Number, as produced by decoding
+// JSON.  It returns 0 and false when the key is missing or its value
+// does not convert.
+func GetInt(p Payload, key string) (int, bool) {
+	switch v := value(p, key).(type) {
+	case int:
+		return v, true
+	case int8:
+		return int(v), true
+	case int16:
+		return int(v), true
+	case int32:
+		return int(v), true
+	case int64:
+		return fromInt64(v)
+	case uint:
+		return fromUint64(uint64(v))
+	case uint8:
+		return int(v), true
+	case uint16:
+		return int(v), true
+	case uint32:
+		return fromUint64(uint64(v))
+	case uint64:
+		return fromUint64(v)
+	case float32:
+		return fromFloat64(float64(v))
+	case float64:
+		return fromFloat64(v)
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return fromInt64(n)
+		}
+		if f, err := v.Float64(); err == nil {
+			return fromFloat64(f)
+		}
+	}
+	return 0, false
+}
+
+// GetFloat64 returns the value stored under key in the data of p as a
+// float64.  Any integer or floating point type converts, and so does a
+// json.Number.  It returns 0 and false when the key is missing or its
+// value does not convert.
+func GetFloat64(p Payload, key string) (float64, bool) {
+	switch v := value(p, key).(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			return f, true
+		}
+	}
+	return 0, false
+}
+
+// GetString returns the string stored under key in the data of p.  It
+// returns "" and false when the key is missing or its value is not a
+// string.
+func GetString(p Payload, key string) (string, bool) {
+	v, ok := value(p, key).(string)
+	return v, ok
+}
+
+// GetBool returns the bool stored under key in the data of p.  It
+// returns false and false when the key is missing or its value is not a
+// bool.
+func GetBool(p Payload, key string) (bool, bool) {
+	v, ok := value(p, key).(bool)
+	return v, ok
+}
+
+// value returns the value stored under key in the data of p, or nil.
+func value(p Payload, key string) interface{} {
+	if p == nil {
+		return nil
+	}
+	return p.Data()[key]
+}
+
+func fromInt64(v int64) (int, bool) {
+	if int64(int(v)) != v {
+		return 0, false
+	}
+	return int(v), true
+}
+
+func fromUint64(v uint64) (int, bool) {
+	if v > math.MaxInt {
+		return 0, false
+	}
+	return int(v), true
+}
+
+func fromFloat64(v float64) (int, bool) {
+	if v != math.Trunc(v) || v < math.MinInt || v >= math.MaxInt {
+		return 0, false
+	}
+	return int(v), true
+}
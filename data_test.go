@@ -0,0 +1,52 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/pajato/event"
+)
+
+func TestDataAccessors(t *testing.T) {
+	e := event.New("testEventData")
+	e.Data()["int"] = 42
+	e.Data()["json"] = float64(7)
+	e.Data()["number"] = json.Number("12")
+	e.Data()["fraction"] = 1.5
+	e.Data()["string"] = "text"
+	e.Data()["bool"] = true
+	if n, ok := GetInt(e, "int"); !ok || n != 42 {
+		t.Errorf("GetInt should return 42, but returned: %v, %v.", n, ok)
+	}
+	if n, ok := GetInt(e, "json"); !ok || n != 7 {
+		t.Errorf("GetInt should convert a whole float64 to 7, but returned: %v, %v.", n, ok)
+	}
+	if n, ok := GetInt(e, "number"); !ok || n != 12 {
+		t.Errorf("GetInt should convert a json.Number to 12, but returned: %v, %v.", n, ok)
+	}
+	if n, ok := GetInt(e, "fraction"); ok || n != 0 {
+		t.Errorf("GetInt should reject a fraction, but returned: %v, %v.", n, ok)
+	}
+	if n, ok := GetInt(e, "string"); ok || n != 0 {
+		t.Errorf("GetInt should reject a string, but returned: %v, %v.", n, ok)
+	}
+	if f, ok := GetFloat64(e, "int"); !ok || f != 42 {
+		t.Errorf("GetFloat64 should convert an int to 42, but returned: %v, %v.", f, ok)
+	}
+	if s, ok := GetString(e, "string"); !ok || s != "text" {
+		t.Errorf("GetString should return \"text\", but returned: %q, %v.", s, ok)
+	}
+	if b, ok := GetBool(e, "bool"); !ok || !b {
+		t.Errorf("GetBool should return true, but returned: %v, %v.", b, ok)
+	}
+	if _, ok := GetString(e, "missing"); ok {
+		t.Error("GetString should report a missing key.")
+	}
+	if _, ok := GetBool(nil, "bool"); ok {
+		t.Error("GetBool should report a nil payload.")
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A dedup remembers when each payload key last passed so that
+// duplicates posted within the window can be dropped.
+type dedup struct {
+	mu     sync.Mutex
+	window time.Duration
+	key    func(p Payload) string
+	seen   map[string]time.Time
+	swept  time.Time
+}
+
+// WithDedup makes the bus drop a post whose key, as computed by keyFn,
+// matches that of a post that passed less than window ago.  The first
+// post of a key passes and starts the window; the duplicates posted
+// within it are dropped without error and counted in the Deduplicated
+// statistic of their type.  A post the bus refuses, because it is
+// full, draining or closed, does not start a window, so it may be
+// retried at once.  Deduplication applies after enrichment and
+// validation, to every kind of post.
+func WithDedup(window time.Duration, keyFn func(p Payload) string) Option {
+	return func(b *Bus) {
+		b.dedup = &dedup{window: window, key: keyFn, seen: make(map[string]time.Time)}
+	}
+}
+
+// duplicate reports whether a post of p is to be dropped as a
+// duplicate, or as a repeat of an idempotency key, counting it if so.
+// A post that is not dropped claims the dedup and idempotency keys of
+// p, which the poster must give back with revoke should the bus refuse
+// the post.
+func (b *Bus) duplicate(p Payload) bool {
+	if b.dedup == nil || !b.dedup.pass(p, b.clock.Now()) {
+		return b.repeated(p)
+	}
+	if b.logs(LogDebug) {
+		b.logger.Printf("Dropping duplicate payload with type: %v.\n", p.Type())
+	}
+	atomic.AddUint64(&b.stats.of(p.Type()).deduplicated, 1)
+	return true
+}
+
+// revoke gives back the keys a post of p claimed in duplicate when err
+// shows that the bus refused the post, so that a retry is not dropped
+// as a duplicate or a repeat of a payload that was never delivered,
+// and returns err.
+func (b *Bus) revoke(p Payload, err error) error {
+	if !refused(err) {
+		return err
+	}
+	if b.dedup != nil {
+		b.dedup.forget(p)
+	}
+	if b.idempotency != nil {
+		if key := b.idempotency.key(p); key != "" {
+			b.idempotency.forget(key)
+		}
+	}
+	return err
+}
+
+// refused reports whether err is the error of a post the bus did not
+// accept: one made to a closed, draining or full bus.
+func refused(err error) bool {
+	be, ok := err.(*busError)
+	return ok && (be.Code == CodeBusClosed || be.Code == CodeBusDraining || be.Code == CodeBusFull)
+}
+
+// pass records the key of p as passing now, returning true if its
+// window is still open.  Expired keys are swept at most once per
+// window.
+func (d *dedup) pass(p Payload, now time.Time) bool {
+	key := d.key(p)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if now.Sub(d.swept) >= d.window {
+		for k, t := range d.seen {
+			if now.Sub(t) >= d.window {
+				delete(d.seen, k)
+			}
+		}
+		d.swept = now
+	}
+	if t, ok := d.seen[key]; ok && now.Sub(t) < d.window {
+		return true
+	}
+	d.seen[key] = now
+	return false
+}
+
+// forget removes the key of p, so that its window is closed.
+func (d *dedup) forget(p Payload) {
+	key := d.key(p)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.seen, key)
+}
@@ -0,0 +1,68 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pajato/event"
+)
+
+func TestDedup(t *testing.T) {
+	b := New(WithDedup(50*time.Millisecond, func(p Payload) string {
+		return fmt.Sprint(p.Type(), p.Data()["key"])
+	}))
+	defer b.Close()
+	name := "cache.invalidate"
+	c := make(chan Payload, 10)
+	b.AddChannel(name, c)
+	post := func(key string) {
+		e := event.New(name)
+		e.Data()["key"] = key
+		if err := b.PostAndWait(e); err != nil {
+			t.Errorf("The post failed with message: %v.\n", err)
+		}
+	}
+	post("x")
+	post("x")
+	post("y")
+	post("x")
+	if n := len(c); n != 2 {
+		t.Errorf("2 payloads should pass, but %v did.", n)
+	}
+	if n := b.Stats().Types[name].Deduplicated; n != 2 {
+		t.Errorf("2 duplicates should be counted, but %v were.", n)
+	}
+	time.Sleep(60 * time.Millisecond)
+	post("x")
+	if n := len(c); n != 3 {
+		t.Errorf("A post after the window should pass, but %v payloads did.", n)
+	}
+}
+
+func TestDedupRefusedPost(t *testing.T) {
+	b := New(WithPubChanBuffer(1), WithAsyncWorkers(1), WithDedup(time.Hour, func(p Payload) string {
+		return fmt.Sprint(p.Type(), p.Data())
+	}))
+	defer b.Close()
+	name := "cache.invalidate"
+	c := make(chan Payload, 1)
+	b.AddChannel(name, c)
+	release := fill(b)
+	e := event.New(name)
+	e.Data()["key"] = "x"
+	if b.TryPost(e) {
+		t.Fatal("A post to a full bus should be refused.")
+	}
+	release()
+	if err := b.PostAndWait(e); err != nil {
+		t.Errorf("The retried post failed with message: %v.\n", err)
+	}
+	if len(c) != 1 || b.Stats().Types[name].Deduplicated != 0 {
+		t.Errorf("The retry should be delivered, not dropped as a duplicate, but %v payloads were.", len(c))
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// A Delivery is the handle of an asynchronous delivery started by
+// PostCancelable.  ID identifies the delivery among those of its bus,
+// for CancelDelivery.
+type Delivery struct {
+	ID   uint64
+	bus  *Bus
+	done <-chan error
+}
+
+// PostCancelable will post a payload asynchronously like PostAsync and
+// return a handle with which to cancel its delivery.  Cancelling
+// cancels the context the delivery is made with: the handlers not yet
+// invoked are skipped, as are the channels, and the ContextHandlers
+// running observe the cancellation through their context.  Plain
+// handlers that are running cannot be interrupted.
+func (b *Bus) PostCancelable(p Payload) (*Delivery, error) {
+	p, err := b.prepare(p)
+	if err != nil {
+		return nil, err
+	}
+	if b.duplicate(p) {
+		return &Delivery{bus: b, done: settled(nil)}, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := rider{payload: p, mode: Asynchronous, ctx: ctx, done: make(chan error, 1), id: atomic.AddUint64(&b.nextID, 1)}
+	b.mu.Lock()
+	b.deliveries[r.id] = cancel
+	b.mu.Unlock()
+	if err := b.send(r); err != nil {
+		b.forget(r.id)
+		return nil, b.revoke(p, err)
+	}
+	return &Delivery{r.id, b, r.done}, nil
+}
+
+// Cancel cancels the delivery and reports whether it was still under
+// way.
+func (d *Delivery) Cancel() bool {
+	return d.bus.CancelDelivery(d.ID)
+}
+
+// Done returns a channel that receives the outcome of the delivery, as
+// PostAsync's does, and is then closed.  A cancelled delivery reports
+// context.Canceled.
+func (d *Delivery) Done() <-chan error {
+	return d.done
+}
+
+// CancelDelivery cancels the asynchronous delivery with the given id,
+// started by PostCancelable, and reports whether it was still under
+// way.
+func (b *Bus) CancelDelivery(id uint64) bool {
+	b.mu.RLock()
+	cancel, ok := b.deliveries[id]
+	b.mu.RUnlock()
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// forget stops tracking a finished cancelable delivery.
+func (b *Bus) forget(id uint64) {
+	b.mu.Lock()
+	cancel := b.deliveries[id]
+	delete(b.deliveries, id)
+	b.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pajato/event"
+)
+
+func TestPostCancelable(t *testing.T) {
+	b := New()
+	defer b.Close()
+	name := "testEventCancelable"
+	started := make(chan struct{})
+	var later int32
+	b.AddContextHandlers(name, func(ctx context.Context, p Payload) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	b.AddHandlers(name, func(p Payload) error {
+		atomic.AddInt32(&later, 1)
+		return nil
+	})
+	d, err := b.PostCancelable(event.New(name))
+	if err != nil {
+		t.Fatalf("Posting failed with message: %v.\n", err)
+	}
+	<-started
+	if !d.Cancel() {
+		t.Error("Cancelling a delivery under way should report true.")
+	}
+	if err := <-d.Done(); !errors.Is(err, context.Canceled) {
+		t.Errorf("A cancelled delivery should report context.Canceled, but reported: %v.", err)
+	}
+	if n := atomic.LoadInt32(&later); n != 0 {
+		t.Errorf("The handlers after the cancellation should be skipped, but one ran %v times.", n)
+	}
+	other, _ := b.PostCancelable(event.New("testEventOther"))
+	<-other.Done()
+	if d.Cancel() || other.Cancel() {
+		t.Error("Cancelling a finished delivery should report false.")
+	}
+}
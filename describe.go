@@ -0,0 +1,116 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A Description is a snapshot of how a bus is configured and wired,
+// made by Describe for logging and support requests.  It holds counts
+// and settings only, never the handlers or channels themselves.
+type Description struct {
+	State          State
+	Workers        int
+	PubChanBuffer  int
+	Queued         int
+	InFlight       int
+	HandlerTimeout time.Duration
+	Types          []TypeDescription
+	Commands       []string
+}
+
+// A TypeDescription describes one payload type or wildcard of a
+// Description.  Mode is zero unless SetTypeMode set one, and RateLimit
+// is the limit per second set by SetRateLimit, zero for none, with
+// Overflow its policy.
+type TypeDescription struct {
+	Type      string
+	Handlers  int
+	Channels  int
+	Mode      Mode
+	RateLimit int
+	Overflow  OverflowPolicy
+}
+
+// Describe returns a Description of the bus, taken in one snapshot
+// under the lock, listing every payload type and wildcard with
+// handlers, channels, a mode or a rate limit, in sorted order.
+func (b *Bus) Describe() Description {
+	d := Description{
+		State:          b.State(),
+		Workers:        b.workers,
+		PubChanBuffer:  b.buffer,
+		HandlerTimeout: b.timeout,
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	d.Queued = b.QueueLen()
+	d.InFlight = b.InFlight()
+	types := make(map[string]*TypeDescription)
+	of := func(typ string) *TypeDescription {
+		td, ok := types[typ]
+		if !ok {
+			td = &TypeDescription{Type: typ}
+			types[typ] = td
+		}
+		return td
+	}
+	for typ, entries := range b.handlers {
+		if len(entries) > 0 {
+			of(typ).Handlers = len(entries)
+		}
+	}
+	for typ, subchans := range b.subchans {
+		if len(subchans) > 0 {
+			of(typ).Channels = len(subchans)
+		}
+	}
+	for typ, mode := range b.modes {
+		of(typ).Mode = mode
+	}
+	for typ, l := range b.limits {
+		td := of(typ)
+		td.RateLimit = int(time.Second / l.interval)
+		td.Overflow = l.policy
+	}
+	for _, td := range types {
+		d.Types = append(d.Types, *td)
+	}
+	sort.Slice(d.Types, func(i, j int) bool { return d.Types[i].Type < d.Types[j].Type })
+	for typ := range b.commands {
+		d.Commands = append(d.Commands, typ)
+	}
+	sort.Strings(d.Commands)
+	return d
+}
+
+// String returns a human readable report of the description, one line
+// for the bus followed by one line per payload type.
+func (d Description) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "bus: %v, %v workers, buffer %v, %v queued, %v in flight", d.State, d.Workers, d.PubChanBuffer, d.Queued, d.InFlight)
+	if d.HandlerTimeout > 0 {
+		fmt.Fprintf(&sb, ", handler timeout %v", d.HandlerTimeout)
+	}
+	sb.WriteString("\n")
+	for _, td := range d.Types {
+		fmt.Fprintf(&sb, "%v: %v handlers, %v channels", td.Type, td.Handlers, td.Channels)
+		if td.Mode != 0 {
+			fmt.Fprintf(&sb, ", delivered %v", td.Mode)
+		}
+		if td.RateLimit > 0 {
+			fmt.Fprintf(&sb, ", limited to %v/s (%v)", td.RateLimit, td.Overflow)
+		}
+		sb.WriteString("\n")
+	}
+	if len(d.Commands) > 0 {
+		fmt.Fprintf(&sb, "commands: %v\n", strings.Join(d.Commands, ", "))
+	}
+	return sb.String()
+}
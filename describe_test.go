@@ -0,0 +1,48 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDescribe(t *testing.T) {
+	b := New(WithAsyncWorkers(2))
+	defer b.Close()
+	b.AddHandlers("user.login", h1, h2)
+	b.AddChannel("user.*", make(chan Payload, 1))
+	b.SetTypeMode("user.login", Synchronous)
+	b.SetRateLimit("order.placed", 10, OverflowDrop)
+	b.AddCommandHandler("command.charge", h1)
+	d := b.Describe()
+	if d.State != Running || d.Workers != 2 || len(d.Types) != 3 {
+		t.Fatalf("The description is wrong: %+v.", d)
+	}
+	want := []TypeDescription{
+		{Type: "order.placed", RateLimit: 10, Overflow: OverflowDrop},
+		{Type: "user.*", Channels: 1},
+		{Type: "user.login", Handlers: 2, Mode: Synchronous},
+	}
+	for i, td := range d.Types {
+		if td != want[i] {
+			t.Errorf("Type %v should be described as %+v, but is: %+v.", i, want[i], td)
+		}
+	}
+	if len(d.Commands) != 1 || d.Commands[0] != "command.charge" {
+		t.Errorf("The commands should be [command.charge], but are: %v.", d.Commands)
+	}
+	s := d.String()
+	for _, line := range []string{
+		"bus: running, 2 workers, buffer 0, 0 queued, 0 in flight\n",
+		"order.placed: 0 handlers, 0 channels, limited to 10/s (drop)\n",
+		"user.login: 2 handlers, 0 channels, delivered synchronously\n",
+		"commands: command.charge\n",
+	} {
+		if !strings.Contains(s, line) {
+			t.Errorf("The report should contain %q, but is:\n%v", line, s)
+		}
+	}
+}
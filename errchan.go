@@ -0,0 +1,91 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import "sync"
+
+// The buffer size of the channels created by AddHandlerWithErrChan.
+const errChanBuffer = 16
+
+// An errChan is the error channel of a handler registered with
+// AddHandlerWithErrChan.  It is closed once, after which errors are
+// discarded.
+type errChan struct {
+	mu     sync.Mutex
+	c      chan error
+	closed bool
+	done   chan struct{}
+}
+
+// AddHandlerWithErrChan will register a handler for a given payload
+// type like AddHandlers and return a channel that receives the errors
+// the handler returns from its asynchronous invocations, so that a
+// supervising goroutine can react to the failures of that handler
+// alone.  The errors of synchronous invocations go to their poster as
+// usual.  The channel is buffered and never holds up delivery: when it
+// is full, the oldest error is dropped to make room.  It is closed once
+// the handler is removed, by Unsubscribe, RemoveHandlers or
+// SetHandlers, or the bus is closed.
+func (b *Bus) AddHandlerWithErrChan(typ string, h Handler) (<-chan error, Subscription, error) {
+	if h == nil {
+		message := "Argument error: a handler must be provided."
+		return nil, Subscription{}, &busError{b.clock.Now(), message, CodeInvalidArgument, nil}
+	}
+	ec := &errChan{c: make(chan error, errChanBuffer), done: make(chan struct{})}
+	s, err := b.add(typ, []*handlerEntry{{fn: h, errs: ec}})
+	if err != nil {
+		return nil, Subscription{}, err
+	}
+	go func() {
+		select {
+		case <-b.quit:
+			ec.close()
+		case <-ec.done:
+		}
+	}()
+	return ec.c, s, nil
+}
+
+// push sends an error to the channel, dropping the oldest error
+// buffered if the channel is full.
+func (ec *errChan) push(err error) {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	if ec.closed {
+		return
+	}
+	for {
+		select {
+		case ec.c <- err:
+			return
+		default:
+		}
+		select {
+		case <-ec.c:
+		default:
+		}
+	}
+}
+
+// close closes the channel unless it is already closed.
+func (ec *errChan) close() {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	if !ec.closed {
+		ec.closed = true
+		close(ec.c)
+		close(ec.done)
+	}
+}
+
+// release closes the error channels of handler entries that have been
+// removed.
+func release(entries []*handlerEntry) {
+	for _, e := range entries {
+		if e.errs != nil {
+			e.errs.close()
+		}
+	}
+}
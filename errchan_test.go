@@ -0,0 +1,56 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pajato/event"
+)
+
+func TestAddHandlerWithErrChan(t *testing.T) {
+	b := New(WithLogLevel(LogOff))
+	defer b.Close()
+	name := "testEventErrChan"
+	failure := errors.New("failure")
+	errc, s, err := b.AddHandlerWithErrChan(name, failWith(failure))
+	if err != nil {
+		t.Fatalf("Registering the handler failed with message: %v.\n", err)
+	}
+	b.AddHandlers(name, failWith(errors.New("other failure")))
+	b.Post(event.New(name))
+	if err := <-errc; err != failure {
+		t.Errorf("The channel should receive the handler's own error, but received: %v.", err)
+	}
+	b.PostAndWait(event.New(name))
+	for i := 0; i < errChanBuffer+4; i++ {
+		b.Post(event.New(name))
+	}
+	b.Wait()
+	if n := len(errc); n != errChanBuffer {
+		t.Errorf("An unread channel should hold the last %v errors, but holds %v.", errChanBuffer, n)
+	}
+	b.Unsubscribe(s)
+	n := 0
+	for range errc {
+		n++
+	}
+	if n != errChanBuffer {
+		t.Errorf("Unsubscribing should close the channel after %v errors, but %v were read.", errChanBuffer, n)
+	}
+}
+
+func TestErrChanClosedWithBus(t *testing.T) {
+	b := New()
+	errc, _, _ := b.AddHandlerWithErrChan("testEventErrChan", h1)
+	b.Close()
+	if _, ok := <-errc; ok {
+		t.Error("Closing the bus should close the channel.")
+	}
+	if _, _, err := b.AddHandlerWithErrChan("testEventErrChan", nil); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("A nil handler should be rejected, but gave: %v.", err)
+	}
+}
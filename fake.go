@@ -0,0 +1,239 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import (
+	"sync"
+	"time"
+)
+
+// A Publisher is the part of a Bus most code needs: posting payloads
+// and subscribing to them.  Code that depends on a Publisher rather
+// than a *Bus can be tested with a RecordingBus.
+type Publisher interface {
+	Post(p Payload) error
+	PostAndWait(p Payload) error
+	AddHandlers(typ string, fns ...Handler) (Subscription, error)
+	AddChannel(typ string, c chan Payload)
+}
+
+var (
+	_ Publisher = (*Bus)(nil)
+	_ Publisher = (*RecordingBus)(nil)
+	_ Clock     = (*FakeClock)(nil)
+)
+
+// A RecordingBus is a Publisher for tests that records the payloads
+// posted to it instead of delivering them.  It starts no goroutine and
+// logs nothing.  Handlers and channels may be added but are never
+// called or sent to.  It is safe for concurrent use.
+type RecordingBus struct {
+	mu     sync.Mutex
+	posted []Payload
+	nextID uint64
+}
+
+// NewFake will create an empty RecordingBus.
+func NewFake() *RecordingBus {
+	return &RecordingBus{}
+}
+
+// Post records a payload.
+func (f *RecordingBus) Post(p Payload) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.posted = append(f.posted, p)
+	return nil
+}
+
+// PostAndWait records a payload like Post.
+func (f *RecordingBus) PostAndWait(p Payload) error {
+	return f.Post(p)
+}
+
+// AddHandlers accepts handlers without ever calling them.  As with a
+// Bus, registering no handlers is an error.
+func (f *RecordingBus) AddHandlers(typ string, fns ...Handler) (Subscription, error) {
+	if len(fns) == 0 {
+		message := "Argument error: at least one handler must be registered."
+		return Subscription{}, &busError{time.Now(), message, CodeNoHandlers, nil}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	return Subscription{f.nextID}, nil
+}
+
+// AddChannel accepts a channel without ever sending to it.
+func (f *RecordingBus) AddChannel(typ string, c chan Payload) {}
+
+// Posted returns a copy of the payloads posted so far, in posting
+// order.
+func (f *RecordingBus) Posted() []Payload {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Payload(nil), f.posted...)
+}
+
+// A FakeClock is a Clock for tests whose time only moves when Advance
+// is called, so that tests of time based features, given a bus created
+// with WithClock, neither sleep nor flake.  Its timers and tickers fire
+// during Advance, in time order, and the functions of AfterFunc run on
+// the goroutine calling Advance.  It is safe for concurrent use.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// A fakeTimer is a timer or ticker of a FakeClock.  A ticker has a
+// period.
+type fakeTimer struct {
+	clock  *FakeClock
+	at     time.Time
+	period time.Duration
+	c      chan time.Time
+	f      func()
+}
+
+// NewFakeClock will create a FakeClock reading the given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the current fake time.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives the fake time once it has
+// advanced by d.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// NewTimer returns a Timer that fires once the fake time has advanced
+// by d.
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	return c.add(&fakeTimer{at: c.Now().Add(d), c: make(chan time.Time, 1)})
+}
+
+// AfterFunc returns a Timer that calls f once the fake time has
+// advanced by d.
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	return c.add(&fakeTimer{at: c.Now().Add(d), f: f})
+}
+
+// NewTicker returns a Ticker that ticks every time the fake time has
+// advanced by d.  Like a real ticker it drops the ticks that are not
+// read in time.
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("bus: non-positive interval for NewTicker")
+	}
+	return fakeTicker{c.add(&fakeTimer{at: c.Now().Add(d), period: d, c: make(chan time.Time, 1)})}
+}
+
+// Timers returns the number of timers and tickers waiting to fire, so
+// that a test can wait for a goroutine to start its timer before
+// advancing the time.
+func (c *FakeClock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// Advance moves the fake time forward by d, firing the timers and
+// tickers that fall due, in time order, as it goes.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	for {
+		t := c.next(end)
+		if t == nil {
+			break
+		}
+		c.now = t.at
+		if t.period > 0 {
+			t.at = t.at.Add(t.period)
+			c.timers = append(c.timers, t)
+		}
+		now := c.now
+		c.mu.Unlock()
+		t.fire(now)
+		c.mu.Lock()
+	}
+	c.now = end
+	c.mu.Unlock()
+}
+
+// next removes and returns the earliest timer due by end, or nil.  The
+// caller must hold the lock.
+func (c *FakeClock) next(end time.Time) *fakeTimer {
+	first := -1
+	for i, t := range c.timers {
+		if !t.at.After(end) && (first < 0 || t.at.Before(c.timers[first].at)) {
+			first = i
+		}
+	}
+	if first < 0 {
+		return nil
+	}
+	t := c.timers[first]
+	c.timers = append(c.timers[:first], c.timers[first+1:]...)
+	return t
+}
+
+// add starts a timer.
+func (c *FakeClock) add(t *fakeTimer) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t.clock = c
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// fire delivers a tick of the timer at the given time.
+func (t *fakeTimer) fire(now time.Time) {
+	if t.f != nil {
+		t.f()
+		return
+	}
+	select {
+	case t.c <- now:
+	default:
+	}
+}
+
+// C returns the channel of the timer, nil for one made by AfterFunc.
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+// Stop stops the timer and reports whether it was still waiting.
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, o := range c.timers {
+		if o == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// A fakeTicker is a ticker of a FakeClock.
+type fakeTicker struct {
+	*fakeTimer
+}
+
+// Stop stops the ticker.
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
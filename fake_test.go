@@ -0,0 +1,29 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/pajato/event"
+)
+
+func TestRecordingBus(t *testing.T) {
+	n := runtime.NumGoroutine()
+	var pub Publisher = NewFake()
+	if runtime.NumGoroutine() != n {
+		t.Error("Creating a recording bus should not start a goroutine.")
+	}
+	pub.Post(event.New("testEventFirst"))
+	pub.PostAndWait(event.New("testEventSecond"))
+	if _, err := pub.AddHandlers("testEventFirst"); err == nil {
+		t.Error("Adding no handlers to a recording bus did not fail as expected.")
+	}
+	posted := pub.(*RecordingBus).Posted()
+	if len(posted) != 2 || posted[0].Type() != "testEventFirst" || posted[1].Type() != "testEventSecond" {
+		t.Errorf("The recording bus should record both posts in order, but recorded: %v.", posted)
+	}
+}
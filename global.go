@@ -0,0 +1,56 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import "unsafe"
+
+// GlobalType is the type the handlers and channels added by
+// AddGlobalHandler and AddGlobalChannel are registered under.  They
+// can be removed like those of any other type, with Unsubscribe,
+// RemoveHandlers(GlobalType) and RemoveChannel(GlobalType, c).
+const GlobalType = "*"
+
+// AddGlobalHandler will register a handler that is invoked for every
+// payload delivered by the bus, whatever its type, after the handlers
+// matching the type, even when one of those stops propagation with
+// ErrStopPropagation.  It suits logging, auditing and debugging, as a
+// wiretap on the bus.  A global handler does not make a payload
+// handled: a payload matching no other handler or channel still goes
+// to the dead letter handler, as well as to the global handlers, and
+// is still forwarded to the parent bus as unhandled.
+func (b *Bus) AddGlobalHandler(h Handler) (Subscription, error) {
+	return b.AddHandlers(GlobalType, h)
+}
+
+// AddGlobalChannel will register a channel that is sent every payload
+// delivered by the bus, whatever its type, like AddGlobalHandler.
+func (b *Bus) AddGlobalChannel(c chan Payload) {
+	b.AddChannel(GlobalType, c)
+}
+
+// wiretaps returns the global handlers and channels that are not among
+// the given ones already.  The caller must hold the read lock.
+func (b *Bus) wiretaps(entries []*handlerEntry, subchans []*channelEntry) ([]*handlerEntry, []*channelEntry) {
+	if len(b.handlers[GlobalType]) == 0 && len(b.subchans[GlobalType]) == 0 {
+		return nil, nil
+	}
+	keys := make(map[unsafe.Pointer]bool, len(entries))
+	for _, e := range entries {
+		keys[e.key()] = true
+	}
+	var taps []*handlerEntry
+	for _, e := range b.handlers[GlobalType] {
+		if !keys[e.key()] {
+			taps = append(taps, e)
+		}
+	}
+	var tapchans []*channelEntry
+	for _, ce := range b.subchans[GlobalType] {
+		if !containsChannel(subchans, ce.c) {
+			tapchans = append(tapchans, ce)
+		}
+	}
+	return taps, tapchans
+}
@@ -0,0 +1,41 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/pajato/event"
+)
+
+func TestGlobalHandler(t *testing.T) {
+	b := New()
+	defer b.Close()
+	var mu sync.Mutex
+	var seen []string
+	b.AddGlobalHandler(func(p Payload) error {
+		mu.Lock()
+		seen = append(seen, p.Type())
+		mu.Unlock()
+		return nil
+	})
+	c := make(chan Payload, 3)
+	b.AddGlobalChannel(c)
+	b.AddHandlers("user.created", h1)
+	var dead []string
+	b.SetDeadLetterHandler(func(p Payload) { dead = append(dead, p.Type()) })
+	for _, typ := range []string{"user.created", "order.placed", "plain"} {
+		b.PostAndWait(event.New(typ))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(seen) != 3 || len(c) != 3 {
+		t.Errorf("The global handler and channel should see all 3 payloads, but saw: %v and %v.", seen, len(c))
+	}
+	if len(dead) != 2 {
+		t.Errorf("Payloads only the globals see should still be dead letters, but the dead letters are: %v.", dead)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The most keys WithIdempotency remembers at once.
+const maxIdempotencyKeys = 1 << 16
+
+// An idempotency remembers the keys of the payloads posted within the
+// ttl, oldest first, so that a payload posted again can be dropped.
+type idempotency struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	key   func(p Payload) string
+	order *list.List
+	seen  map[string]*list.Element
+}
+
+// A seenKey is a key remembered by an idempotency.
+type seenKey struct {
+	key string
+	at  time.Time
+}
+
+// WithIdempotency makes the bus deliver each payload key, as computed
+// by keyFn, at most once: a post whose key was posted less than ttl ago
+// is dropped without error, logged and counted in the Repeated
+// statistic of its type.  Unlike WithDedup, which debounces, a repeat
+// does not extend the ttl of a key, so the key is forgotten ttl after
+// it was first posted.  A post the bus refuses, because it is full,
+// draining or closed, leaves no key behind, so it may be retried.
+// Payloads with an empty key are always delivered.  Memory is bounded:
+// at most 65536 keys are remembered, the oldest being forgotten first,
+// so a key may repeat undetected if more keys than that are posted
+// within the ttl.  The check applies after enrichment, validation and
+// deduplication, to every kind of post.
+func WithIdempotency(keyFn func(p Payload) string, ttl time.Duration) Option {
+	return func(b *Bus) {
+		b.idempotency = &idempotency{ttl: ttl, key: keyFn, order: list.New(), seen: make(map[string]*list.Element)}
+	}
+}
+
+// repeated reports whether a post of p is to be dropped as a repeat of
+// an idempotency key, counting it if so.
+func (b *Bus) repeated(p Payload) bool {
+	if b.idempotency == nil {
+		return false
+	}
+	key := b.idempotency.key(p)
+	if key == "" || !b.idempotency.seenBefore(key, b.clock.Now()) {
+		return false
+	}
+	if b.logs(LogDebug) {
+		b.logger.Printf("Dropping repeated payload with type: %v and key: %v.\n", p.Type(), key)
+	}
+	atomic.AddUint64(&b.stats.of(p.Type()).repeated, 1)
+	return true
+}
+
+// seenBefore reports whether key was recorded less than the ttl
+// before now, recording it if not.  The expired keys, and the oldest
+// beyond the limit, are forgotten.
+func (id *idempotency) seenBefore(key string, now time.Time) bool {
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	for e := id.order.Back(); e != nil; e = id.order.Back() {
+		sk := e.Value.(*seenKey)
+		if now.Sub(sk.at) < id.ttl && id.order.Len() < maxIdempotencyKeys {
+			break
+		}
+		id.order.Remove(e)
+		delete(id.seen, sk.key)
+	}
+	if _, ok := id.seen[key]; ok {
+		return true
+	}
+	id.seen[key] = id.order.PushFront(&seenKey{key, now})
+	return false
+}
+
+// forget removes key, so that it is no longer seen before.
+func (id *idempotency) forget(key string) {
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	if e, ok := id.seen[key]; ok {
+		id.order.Remove(e)
+		delete(id.seen, key)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"container/list"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pajato/event"
+)
+
+func TestIdempotency(t *testing.T) {
+	b := New(WithIdempotency(func(p Payload) string {
+		id, _ := GetString(p, "id")
+		return id
+	}, time.Hour))
+	defer b.Close()
+	name := "order.placed"
+	c := make(chan Payload, 10)
+	b.AddChannel(name, c)
+	post := func(id string) {
+		e := event.New(name)
+		if id != "" {
+			e.Data()["id"] = id
+		}
+		if err := b.PostAndWait(e); err != nil {
+			t.Errorf("The post failed with message: %v.\n", err)
+		}
+	}
+	post("a")
+	post("a")
+	post("b")
+	post("")
+	post("")
+	if n := len(c); n != 4 {
+		t.Errorf("4 payloads should pass, but %v did.", n)
+	}
+	if n := b.Stats().Types[name].Repeated; n != 1 {
+		t.Errorf("1 repeat should be counted, but %v were.", n)
+	}
+}
+
+func TestIdempotencyExpiry(t *testing.T) {
+	id := &idempotency{ttl: 20 * time.Millisecond, order: list.New(), seen: make(map[string]*list.Element)}
+	now := time.Now()
+	if id.seenBefore("a", now) || !id.seenBefore("a", now.Add(10*time.Millisecond)) {
+		t.Error("A key should be seen before only on its second post.")
+	}
+	if id.seenBefore("a", now.Add(30*time.Millisecond)) {
+		t.Error("A key should be forgotten after the ttl.")
+	}
+	id = &idempotency{ttl: time.Hour, order: list.New(), seen: make(map[string]*list.Element)}
+	for i := 0; i < maxIdempotencyKeys+1; i++ {
+		id.seenBefore(fmt.Sprint(i), now)
+	}
+	if n := len(id.seen); n != maxIdempotencyKeys {
+		t.Errorf("At most %v keys should be remembered, but %v are.", maxIdempotencyKeys, n)
+	}
+}
+
+func TestIdempotencyRefusedPost(t *testing.T) {
+	b := New(WithPubChanBuffer(1), WithAsyncWorkers(1), WithIdempotency(func(p Payload) string {
+		id, _ := GetString(p, "id")
+		return id
+	}, time.Hour))
+	defer b.Close()
+	name := "order.placed"
+	var ids []string
+	b.AddHandlers(name, func(p Payload) error {
+		id, _ := GetString(p, "id")
+		ids = append(ids, id)
+		return nil
+	})
+	release := fill(b)
+	e := event.New(name)
+	e.Data()["id"] = "a"
+	if b.TryPost(e) {
+		t.Fatal("A post to a full bus should be refused.")
+	}
+	release()
+	if err := b.PostAndWait(e); err != nil {
+		t.Errorf("The retried post failed with message: %v.\n", err)
+	}
+	b.Wait()
+	if len(ids) != 1 || b.Stats().Types[name].Repeated != 0 {
+		t.Errorf("The retry should be delivered, not dropped as a repeat, but the deliveries are: %v.", ids)
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import (
+	"context"
+	"iter"
+)
+
+// Iter will return an iterator over the payloads delivered for a given
+// payload type, so that a consumer can write
+//
+//	for p := range b.Iter(ctx, "user.created") { ... }
+//
+// Each range over the iterator subscribes afresh, as Subscribe does,
+// and so sees only the payloads posted once the loop has started.  The
+// loop ends when ctx is done or the bus closes, and the subscription is
+// cancelled as soon as the loop ends, whether for one of those reasons
+// or because its body breaks out of it or returns.
+//
+// The payloads are buffered, up to 64, while the loop body runs.  A
+// body slower than the payloads are posted fills the buffer, after
+// which the bus blocks delivering each further payload until the body
+// takes the next one, holding up the posts behind it as a subscriber
+// channel registered with AddChannel would.  Payloads still buffered
+// when the loop ends are discarded.
+func (b *Bus) Iter(ctx context.Context, typ string) iter.Seq[Payload] {
+	return func(yield func(Payload) bool) {
+		c, cancel := b.Subscribe(typ)
+		defer cancel()
+		for {
+			select {
+			case p := <-c:
+				if !yield(p) {
+					return
+				}
+			case <-ctx.Done():
+				return
+			case <-b.quit:
+				return
+			}
+		}
+	}
+}
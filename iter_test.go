@@ -0,0 +1,60 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pajato/event"
+)
+
+func TestIter(t *testing.T) {
+	b := New()
+	defer b.Close()
+	name := "testEventIter"
+	got := make(chan int)
+	go func() {
+		n := 0
+		for range b.Iter(context.Background(), name) {
+			if n++; n == 3 {
+				break
+			}
+		}
+		got <- n
+	}()
+	for i := 0; i < 100 && b.ChannelCount(name) == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 3; i++ {
+		b.PostAndWait(event.New(name))
+	}
+	if n := <-got; n != 3 {
+		t.Errorf("The loop should receive 3 payloads, but received %v.", n)
+	}
+	if n := b.ChannelCount(name); n != 0 {
+		t.Errorf("Breaking out of the loop should cancel the subscription, but the count is: %v.", n)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		for range b.Iter(ctx, name) {
+		}
+		close(done)
+	}()
+	for i := 0; i < 100 && b.ChannelCount(name) == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Cancelling the context did not end the loop.")
+	}
+	if n := b.ChannelCount(name); n != 0 {
+		t.Errorf("Cancelling the context should cancel the subscription, but the count is: %v.", n)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import (
+	"sync"
+	"time"
+)
+
+// A sampler lets one per-payload trace line of each kind and payload
+// type through per interval, counting the lines it suppresses.  It is
+// a token bucket holding a single token.
+type sampler struct {
+	mu       sync.Mutex
+	interval time.Duration
+	lines    map[sampleKey]*sample
+}
+
+// The key of the lines sampled together.
+type sampleKey struct {
+	kind string
+	typ  string
+}
+
+// A sample tracks when a kind of line was last logged for a payload
+// type and how many were suppressed since.
+type sample struct {
+	last       time.Time
+	suppressed uint64
+}
+
+// WithLogSampling makes the bus log the per-payload trace lines of the
+// LogDebug level, such as those broadcasting a payload and those
+// handing it to each subscriber, at most once per interval for each
+// kind of line and payload type.  The next line logged after some were
+// suppressed is preceded by their count.  The lines of the other
+// levels, notably errors, are never sampled.  Without it every line is
+// logged.
+func WithLogSampling(interval time.Duration) Option {
+	return func(b *Bus) {
+		b.sampler = &sampler{interval: interval, lines: make(map[sampleKey]*sample)}
+	}
+}
+
+// traces reports whether to log a per-payload trace line of the given
+// kind for a payload type, logging the count of the lines suppressed
+// before it.
+func (b *Bus) traces(kind, typ string) bool {
+	if !b.logs(LogDebug) {
+		return false
+	}
+	if b.sampler == nil {
+		return true
+	}
+	ok, suppressed := b.sampler.take(sampleKey{kind, typ}, b.clock.Now())
+	if ok && suppressed > 0 {
+		b.logger.Printf("Suppressed %v %v log lines for payload with type: %v.\n", suppressed, kind, typ)
+	}
+	return ok
+}
+
+// take reports whether the interval of a kind of line has passed, by
+// now, since it was last logged, along with the number of lines
+// suppressed in the meantime, and otherwise counts one more suppressed
+// line.
+func (s *sampler) take(key sampleKey, now time.Time) (bool, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.lines[key]
+	if !ok {
+		l = &sample{}
+		s.lines[key] = l
+	}
+	if !l.last.IsZero() && now.Sub(l.last) < s.interval {
+		l.suppressed++
+		return false, 0
+	}
+	suppressed := l.suppressed
+	l.last, l.suppressed = now, 0
+	return true, suppressed
+}
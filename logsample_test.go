@@ -0,0 +1,44 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pajato/event"
+)
+
+func TestLogSampling(t *testing.T) {
+	var buf bytes.Buffer
+	b := New(WithLogger(log.New(&buf, "", 0)), WithLogLevel(LogDebug), WithLogSampling(time.Hour))
+	name := "testEventSampling"
+	b.AddHandlers(name, failWith(errors.New("failure")))
+	for i := 0; i < 10; i++ {
+		b.PostAndWait(event.New(name))
+	}
+	b.Close()
+	if n := strings.Count(buf.String(), "Broadcasting payload"); n != 1 {
+		t.Errorf("The broadcast line should be logged once, but was logged %v times.", n)
+	}
+	if n := strings.Count(buf.String(), "failed: failure"); n != 10 {
+		t.Errorf("Every error should be logged, but %v were.", n)
+	}
+	if l := b.sampler.lines[sampleKey{"broadcast", name}]; l == nil || l.suppressed != 9 {
+		t.Errorf("9 broadcast lines should be counted as suppressed, but the sample is: %+v.", l)
+	}
+	s := &sampler{interval: 50 * time.Millisecond, lines: make(map[sampleKey]*sample)}
+	key := sampleKey{"broadcast", name}
+	now := time.Now()
+	s.take(key, now)
+	s.take(key, now.Add(10*time.Millisecond))
+	if ok, suppressed := s.take(key, now.Add(60*time.Millisecond)); !ok || suppressed != 1 {
+		t.Errorf("A line after the interval should report 1 suppressed line, but gave: %v and %v.", ok, suppressed)
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import "strings"
+
+// The payload types of the meta-events posted by a bus created with
+// WithMetaEvents.  Every payload type starting with MetaPrefix is
+// reserved for the bus; registering for one of them, or for a wildcard
+// matching them, posts no meta-event, so monitoring code can subscribe
+// to meta-events without causing more of them.
+const (
+	MetaPrefix       = "bus."
+	MetaSubscribed   = MetaPrefix + "subscribed"
+	MetaUnsubscribed = MetaPrefix + "unsubscribed"
+)
+
+// The keys of the data of a meta-event.  The value under MetaTypeKey is
+// the payload type whose subscribers changed and the value under
+// MetaCountKey is the number of handlers and channels, an int, left
+// registered for it after the change.
+const (
+	MetaTypeKey  = "bus.type"
+	MetaCountKey = "bus.count"
+)
+
+// announce posts a meta-event of the given type for a change to the
+// subscribers of typ when meta-events are enabled.  The caller must
+// hold the lock.  The meta-event is posted on its own goroutine so that
+// a change made while delivering, such as the removal of a once
+// handler, cannot block the bus goroutine.
+func (b *Bus) announce(meta, typ string) {
+	if !b.meta || strings.HasPrefix(typ, MetaPrefix) || isMetaWildcard(typ) {
+		return
+	}
+	count := len(b.handlers[typ]) + len(b.subchans[typ])
+	p := NewPayload(meta, map[string]interface{}{MetaTypeKey: typ, MetaCountKey: count}, nil)
+	go b.Post(p)
+}
+
+// isMetaWildcard reports whether typ is a wildcard matching the
+// meta-event types.
+func isMetaWildcard(typ string) bool {
+	return strings.HasSuffix(typ, ".*") && strings.HasPrefix(MetaPrefix, strings.TrimSuffix(typ, "*"))
+}
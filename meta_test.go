@@ -0,0 +1,48 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMetaEvents(t *testing.T) {
+	b := New(WithMetaEvents())
+	defer b.Close()
+	c := make(chan Payload, 4)
+	b.AddChannel("bus.*", c)
+	b.AddChannel(MetaSubscribed, c)
+	name := "testEventMeta"
+	b.AddHandlers(name, h1, h2)
+	select {
+	case p := <-c:
+		if p.Type() != MetaSubscribed {
+			t.Errorf("The meta-event type should be %v, but is: %v.", MetaSubscribed, p.Type())
+		}
+		if typ := p.Data()[MetaTypeKey]; typ != name {
+			t.Errorf("The meta-event should be for %v, but is for: %v.", name, typ)
+		}
+		if n := p.Data()[MetaCountKey]; n != 2 {
+			t.Errorf("The meta-event count should be 2, but is: %v.", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("No meta-event was posted for the new handlers.")
+	}
+	b.RemoveHandlers(name)
+	select {
+	case p := <-c:
+		if p.Type() != MetaUnsubscribed || p.Data()[MetaCountKey] != 0 {
+			t.Errorf("An unsubscribed meta-event with count 0 should be posted, but got: %v %v.", p.Type(), p.Data())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("No meta-event was posted for the removed handlers.")
+	}
+	select {
+	case p := <-c:
+		t.Errorf("No other meta-event should be posted, but got: %v %v.", p.Type(), p.Data())
+	case <-time.After(20 * time.Millisecond):
+	}
+}
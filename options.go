@@ -0,0 +1,254 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import "time"
+
+// An Option configures a Bus created by New.
+type Option func(b *Bus)
+
+// WithLogger makes the bus log to the given logger.  A nil logger
+// selects the default.
+func WithLogger(logger Logger) Option {
+	return func(b *Bus) {
+		b.logger = logger
+	}
+}
+
+// WithPubChanBuffer gives the channel on which payloads are posted a
+// buffer of n riders, so posts need not wait for the bus goroutine.
+func WithPubChanBuffer(n int) Option {
+	return func(b *Bus) {
+		b.buffer = n
+	}
+}
+
+// WithAsyncWorkers sets the number of workers delivering asynchronous
+// posts, and so the number of asynchronous deliveries that may run at
+// once, to n.  Posts beyond that wait in the posting channel, so that
+// handlers posting from every worker at once deadlock the bus; see
+// PostAndWait.  Zero selects the default of one worker per CPU.
+func WithAsyncWorkers(n int) Option {
+	return func(b *Bus) {
+		b.workers = n
+	}
+}
+
+// WithHandlerTimeout limits how long delivery waits for any one
+// handler to d.  A handler still running after d is reported as failed
+// with a timeout error and delivery moves on to the next subscriber.
+// Go cannot stop the handler, so it keeps running on a goroutine of
+// its own until it returns, which may be never.  Zero, the default,
+// means no limit.
+func WithHandlerTimeout(d time.Duration) Option {
+	return func(b *Bus) {
+		b.timeout = d
+	}
+}
+
+// WithOrderedAsync makes asynchronous delivery preserve the order in
+// which payloads of the same type are posted, as event sourcing needs.
+// Each payload type gets a lane, a queue with a goroutine of its own
+// delivering its payloads one at a time, so different types still
+// proceed concurrently.  The lanes replace the worker pool for
+// asynchronous delivery.  A lane holds up to 256 payloads; when a lane
+// is full, the bus goroutine waits for room before handling the next
+// post of any type.
+func WithOrderedAsync() Option {
+	return func(b *Bus) {
+		b.ordered = true
+	}
+}
+
+// WithNonBlockingPosts makes posts fail with an error wrapping
+// ErrBusFull, rather than wait, when the buffer of the posting channel
+// is full.  It is most useful together with WithPubChanBuffer.
+func WithNonBlockingPosts() Option {
+	return func(b *Bus) {
+		b.nonblocking = true
+	}
+}
+
+// WithPayloadValidator makes the bus check every payload with fn before
+// posting it, so that application rules, such as required data keys,
+// are enforced where payloads enter the bus.  A payload fn returns an
+// error for is not posted and the post fails with an error wrapping
+// the one from fn.
+func WithPayloadValidator(fn func(p Payload) error) Option {
+	return func(b *Bus) {
+		b.validator = fn
+	}
+}
+
+// WithPayloadEnricher makes the bus pass every payload through fn once
+// as it is posted and deliver the payload fn returns, to every handler
+// and channel, in its place.  This is the place to stamp payloads with
+// sequence numbers, timestamps or trace ids.  fn runs before the
+// payload is validated and routed by type; a post for which fn returns
+// nil fails with an error.
+func WithPayloadEnricher(fn func(p Payload) Payload) Option {
+	return func(b *Bus) {
+		b.enricher = fn
+	}
+}
+
+// WithMetaEvents makes the bus post a MetaSubscribed or MetaUnsubscribed
+// payload whenever handlers or channels are added for a payload type or
+// removed from it.  Meta-events are posted asynchronously, so they may
+// arrive out of order with respect to each other.  They are off by
+// default.
+func WithMetaEvents() Option {
+	return func(b *Bus) {
+		b.meta = true
+	}
+}
+
+// WithChannelGracePeriod makes the bus give up on a send to a
+// subscriber channel that has blocked for d, logging a warning and
+// moving on, so that a subscriber that has stopped reading cannot hang
+// delivery for everyone.  It applies to the channels whose options
+// block without a SendTimeout, which includes every channel added with
+// AddChannel.  The default, zero, waits indefinitely.
+func WithChannelGracePeriod(d time.Duration) Option {
+	return func(b *Bus) {
+		b.grace = d
+	}
+}
+
+// WithInlineSyncDelivery makes synchronous posts deliver their payload
+// on the posting goroutine instead of handing it to the bus goroutine,
+// saving a channel round trip and a goroutine switch per post.  The
+// price is in the guarantees: synchronous posts no longer queue behind
+// earlier posts in the posting channel, so a PostAndWait may be
+// delivered before an asynchronous post made just before it, and they
+// bypass Pause, Flush and the buffer of WithNonBlockingPosts.  Handlers
+// run on the poster's goroutine, so a handler that blocks holds up its
+// poster only, and a cancelled context still stops delivery between
+// handlers but no longer lets the poster return while a handler runs.
+// Asynchronous posts are unaffected.
+func WithInlineSyncDelivery() Option {
+	return func(b *Bus) {
+		b.inline = true
+	}
+}
+
+// WithStopOnError makes a synchronous delivery stop at the first
+// handler that fails and return its error as is, instead of running
+// every handler and returning a MultiError, so that the handlers of a
+// type form a validation gate run in order.  The handlers after the
+// failing one do not run and, in the default HandlersFirst order, the
+// channels are not sent the payload.  Asynchronous deliveries still
+// run every handler.
+func WithStopOnError() Option {
+	return func(b *Bus) {
+		b.stopOnError = true
+	}
+}
+
+// The most handlers of one payload that WithParallelHandlers runs at
+// once.
+const maxParallelHandlers = 64
+
+// WithParallelHandlers makes the bus invoke the handlers of a payload
+// all at once, up to 64 at a time, rather than one after the other, so
+// that independent slow handlers, such as those calling different
+// services, do not add up.  Delivery still waits for every handler,
+// and a synchronous post still returns their errors in handler order.
+// No ordering applies among the handlers of a payload: priorities do
+// not order them, ErrStopPropagation does not skip the others and
+// neither does WithStopOnError.  The handlers must be safe to run
+// concurrently with each other, and so must any middleware.
+func WithParallelHandlers() Option {
+	return func(b *Bus) {
+		b.parallel = true
+	}
+}
+
+// WithSlowHandlerThreshold makes the bus time every handler
+// invocation and log a warning, naming the payload type and the index
+// of the handler, for each one running longer than d.  The slow
+// invocations are counted in the Slow statistic of their type, which
+// also reports the longest invocation and its handler.  Unlike
+// WithHandlerTimeout it only observes: the handler runs to completion
+// and its outcome is unchanged.  Zero, the default, times nothing.
+func WithSlowHandlerThreshold(d time.Duration) Option {
+	return func(b *Bus) {
+		b.slow = d
+	}
+}
+
+// A DeliveryOrder tells in what order a payload reaches its handlers
+// and its subscriber channels.
+type DeliveryOrder int
+
+// The delivery orders.  Under HandlersFirst, the default, the channels
+// are sent the payload once every handler has run, and under
+// ChannelsFirst the handlers run once every channel has accepted the
+// payload, or given up on it.  Under Interleaved the channels are sent
+// the payload while the handlers run.  In every order the handlers run
+// one after the other, a synchronous post waits for both kinds of
+// subscriber and Deliver reports the handlers before the channels.
+const (
+	HandlersFirst DeliveryOrder = iota
+	ChannelsFirst
+	Interleaved
+)
+
+// WithDeliveryOrder sets the order in which payloads reach handlers
+// and channels.
+func WithDeliveryOrder(order DeliveryOrder) Option {
+	return func(b *Bus) {
+		b.order = order
+	}
+}
+
+// A PanicPolicy tells the bus what to do when a handler panics.
+type PanicPolicy int
+
+// The panic policies.  Under PanicContinue, the default, a panic is
+// logged with the stack of the handler and swallowed, so the handler
+// counts as succeeding.  Under PanicAsError it is logged and becomes
+// an ErrHandlerPanic error of the handler, which fails its post like
+// any handler error.  Under PanicRepanic it is logged and raised
+// again, to fail fast while developing and testing.  A panic raised
+// again is only recoverable by the poster when the handler runs on the
+// posting goroutine, as with Dispatch or a synchronous post on a bus
+// created with WithInlineSyncDelivery without a handler timeout.
+// Asynchronous deliveries, and the other synchronous ones, run on
+// goroutines of the bus, where the panic crashes the program.
+const (
+	PanicContinue PanicPolicy = iota
+	PanicAsError
+	PanicRepanic
+)
+
+// WithPanicPolicy sets what the bus does when a handler panics.
+func WithPanicPolicy(policy PanicPolicy) Option {
+	return func(b *Bus) {
+		b.panics = policy
+	}
+}
+
+// WithRoutingKeyFunc makes the bus look up the subscribers of a
+// payload by the key keyFn computes for it instead of by its type, so
+// that payloads of one type can reach different subscribers, say by
+// the tenant in their data.  Handlers and channels are then registered
+// for routing keys, which may be wildcards as usual, and still receive
+// the whole payload.  A payload whose key is empty is routed by its
+// type.  Statistics, type modes and the other settings by type still
+// go by the payload type.
+func WithRoutingKeyFunc(keyFn func(p Payload) string) Option {
+	return func(b *Bus) {
+		b.router = keyFn
+	}
+}
+
+// WithLogLevel sets how much the bus logs.  At LogOff it logs nothing,
+// not even through Log.
+func WithLogLevel(level LogLevel) Option {
+	return func(b *Bus) {
+		b.level = level
+	}
+}
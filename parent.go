@@ -0,0 +1,139 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import (
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+// SetParent makes the bus forward payloads to a parent bus.  Given
+// types, which may include wildcards, the payloads of those types are
+// forwarded; given none, the payloads that no local handler or channel
+// subscribes to are.  A payload is forwarded once its local delivery
+// is complete, before a synchronous poster is told the outcome, and is
+// posted to the parent as if by parent.Post, so the parent's modes and
+// options apply to it and its errors are logged rather than returned
+// to the poster.  A parent of nil stops the forwarding.  Setting a
+// parent that is the bus itself or one of its descendants is an error.
+func (b *Bus) SetParent(parent *Bus, types ...string) error {
+	// Hold the lineage locks of the bus and of its would-be ancestors,
+	// so that no concurrent SetParent can change the ancestry checked
+	// for a cycle, trying again if it changed before they were held.
+	line, _ := b.ancestry(parent)
+	var cycle bool
+	for {
+		lockLineage(line)
+		var current []*Bus
+		if current, cycle = b.ancestry(parent); sameBuses(current, line) {
+			break
+		}
+		unlockLineage(line)
+		line = current
+	}
+	defer unlockLineage(line)
+	if cycle {
+		message := "Parent error: setting the parent would create a cycle of buses."
+		return &busError{b.clock.Now(), message, CodeInvalidArgument, nil}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.parent = parent
+	b.forwarded = nil
+	if len(types) > 0 {
+		b.forwarded = make(map[string]bool, len(types))
+		for _, typ := range types {
+			b.forwarded[typ] = true
+		}
+	}
+	return nil
+}
+
+// ancestry returns the bus followed by parent and its ancestors, and
+// reports whether the bus is among them, so that making parent its
+// parent would create a cycle.
+func (b *Bus) ancestry(parent *Bus) ([]*Bus, bool) {
+	line := []*Bus{b}
+	for p := parent; p != nil; p = p.parentOf() {
+		if p == b {
+			return line, true
+		}
+		line = append(line, p)
+	}
+	return line, false
+}
+
+// lockLineage takes the lineage locks of the given buses in address
+// order, so that concurrent calls cannot deadlock.
+func lockLineage(line []*Bus) {
+	ordered := append([]*Bus(nil), line...)
+	sort.Slice(ordered, func(i, j int) bool {
+		return uintptr(unsafe.Pointer(ordered[i])) < uintptr(unsafe.Pointer(ordered[j]))
+	})
+	for _, bus := range ordered {
+		bus.lineage.Lock()
+	}
+}
+
+// unlockLineage releases the locks taken by lockLineage.
+func unlockLineage(line []*Bus) {
+	for _, bus := range line {
+		bus.lineage.Unlock()
+	}
+}
+
+// sameBuses reports whether two lists hold the same buses in the same
+// order.
+func sameBuses(a, b []*Bus) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *Bus) parentOf() *Bus {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.parent
+}
+
+// forwardee returns the bus to forward a payload of the given type to,
+// if any.  The caller must hold the lock.
+func (b *Bus) forwardee(typ string, handled bool) *Bus {
+	if b.parent == nil {
+		return nil
+	}
+	if b.forwarded == nil {
+		if handled {
+			return nil
+		}
+		return b.parent
+	}
+	if b.forwarded[typ] {
+		return b.parent
+	}
+	for i := strings.LastIndex(typ, "."); i >= 0; i = strings.LastIndex(typ[:i], ".") {
+		if b.forwarded[typ[:i+1]+"*"] {
+			return b.parent
+		}
+	}
+	return nil
+}
+
+// forward posts a locally delivered payload to the parent bus.
+func (b *Bus) forward(parent *Bus, p Payload) {
+	if b.logs(LogDebug) {
+		b.logger.Printf("Forwarding payload with type: %v to the parent bus.\n", p.Type())
+	}
+	if err := parent.Post(p); err != nil && b.logs(LogError) {
+		b.logger.Printf("Forwarding payload with type: %v failed: %v.\n", p.Type(), err)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pajato/event"
+)
+
+func TestSetParentForwardsUnhandled(t *testing.T) {
+	parent, child := New(), New()
+	defer parent.Close()
+	defer child.Close()
+	var handled, unhandled int32
+	parent.AddHandlers("testEventHandled", func(p Payload) error {
+		atomic.AddInt32(&handled, 1)
+		return nil
+	})
+	parent.AddHandlers("testEventUnhandled", func(p Payload) error {
+		atomic.AddInt32(&unhandled, 1)
+		return nil
+	})
+	child.AddHandlers("testEventHandled", h1)
+	if err := child.SetParent(parent); err != nil {
+		t.Fatalf("Setting the parent failed with message: %v.\n", err)
+	}
+	child.PostAndWait(event.New("testEventHandled"))
+	child.PostAndWait(event.New("testEventUnhandled"))
+	parent.Wait()
+	if n := atomic.LoadInt32(&handled); n != 0 {
+		t.Errorf("A locally handled payload should not be forwarded, but was forwarded %v times.", n)
+	}
+	if n := atomic.LoadInt32(&unhandled); n != 1 {
+		t.Errorf("An unhandled payload should be forwarded once, but was forwarded %v times.", n)
+	}
+}
+
+func TestSetParentForwardsTypes(t *testing.T) {
+	parent, child := New(), New()
+	defer parent.Close()
+	defer child.Close()
+	var n int32
+	parent.AddHandlers("audit.*", func(p Payload) error {
+		atomic.AddInt32(&n, 1)
+		return nil
+	})
+	child.AddHandlers("audit.login", h1)
+	child.SetParent(parent, "audit.*")
+	child.PostAndWait(event.New("audit.login"))
+	child.PostAndWait(event.New("other"))
+	parent.Wait()
+	if n := atomic.LoadInt32(&n); n != 1 {
+		t.Errorf("Only the matching payload should be forwarded, but %v payloads were.", n)
+	}
+}
+
+func TestSetParentCycle(t *testing.T) {
+	a, b, c := New(), New(), New()
+	defer a.Close()
+	defer b.Close()
+	defer c.Close()
+	if err := b.SetParent(a); err != nil {
+		t.Fatalf("Setting the parent failed with message: %v.\n", err)
+	}
+	c.SetParent(b)
+	if err := a.SetParent(c); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("A cycle of parents should be an invalid argument, but the error is: %v.", err)
+	}
+	if err := a.SetParent(a); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("A bus should not be its own parent, but the error is: %v.", err)
+	}
+}
+
+func TestSetParentConcurrentCycle(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		a, b := New(), New()
+		errs := make(chan error, 2)
+		go func() { errs <- a.SetParent(b) }()
+		go func() { errs <- b.SetParent(a) }()
+		if err1, err2 := <-errs, <-errs; (err1 == nil) == (err2 == nil) {
+			t.Fatalf("Exactly one of two buses should become the parent of the other, but the errors are: %v and %v.", err1, err2)
+		}
+		a.Close()
+		b.Close()
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// A payload is the Payload made by NewPayload.  Its data and headers
+// are never nil.  A pooled payload was made by AcquirePayload.
+type payload struct {
+	typ    string
+	data   map[string]interface{}
+	meta   map[string]interface{}
+	pooled bool
+}
+
+// NewPayload will create a Payload with the given type, data and
+// headers, so that payloads can be posted without a separate event
+// package.  The result satisfies MetaPayload.  Nil data or headers
+// are replaced by an empty map, so Data and Meta never return nil.
+// The maps are used as is, not copied.
+func NewPayload(typ string, data, headers map[string]interface{}) Payload {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	if headers == nil {
+		headers = map[string]interface{}{}
+	}
+	return &payload{typ, data, headers, false}
+}
+
+// Type returns the payload type.
+func (p *payload) Type() string {
+	return p.typ
+}
+
+// Data returns the payload data.
+func (p *payload) Data() map[string]interface{} {
+	return p.data
+}
+
+// Meta returns the payload headers.
+func (p *payload) Meta() map[string]interface{} {
+	return p.meta
+}
+
+// headers returns the map holding the routing and tracing metadata of
+// p: its headers when it is a MetaPayload with headers, or else its
+// data.
+func headers(p Payload) map[string]interface{} {
+	if mp, ok := p.(MetaPayload); ok {
+		if meta := mp.Meta(); meta != nil {
+			return meta
+		}
+	}
+	return p.Data()
+}
+
+// Header returns the value stored under key in the headers of p, as
+// the bus stores it, falling back to the data of a payload without
+// headers.  It returns nil and false when the key is missing.
+func Header(p Payload, key string) (interface{}, bool) {
+	if p == nil {
+		return nil, false
+	}
+	v, ok := headers(p)[key]
+	return v, ok
+}
+
+// payloads pools the payloads of AcquirePayload.
+var payloads = sync.Pool{
+	New: func() interface{} {
+		return &payload{data: map[string]interface{}{}, meta: map[string]interface{}{}, pooled: true}
+	},
+}
+
+// AcquirePayload will return a payload with the given type and empty
+// data and headers like NewPayload, reusing one released with
+// ReleasePayload when it can, so that publishers posting at a high
+// rate allocate less.
+func AcquirePayload(typ string) Payload {
+	p := payloads.Get().(*payload)
+	p.typ = typ
+	return p
+}
+
+// ReleasePayload will empty a payload made by AcquirePayload and return
+// it to the pool for reuse; other payloads, including those made by
+// NewPayload or UnmarshalPayload, whose maps belong to the caller, are
+// ignored.  Release a payload only once its delivery is complete, as
+// when PostAndWait has returned, and do not use it afterwards.
+// Releasing a payload that is still queued, or still referenced by an
+// asynchronous handler, a subscriber channel, the replay buffer or a
+// results list, lets the reused payload change under that reader.
+func ReleasePayload(p Payload) {
+	pp, ok := p.(*payload)
+	if !ok || !pp.pooled {
+		return
+	}
+	for k := range pp.data {
+		delete(pp.data, k)
+	}
+	for k := range pp.meta {
+		delete(pp.meta, k)
+	}
+	pp.typ = ""
+	payloads.Put(pp)
+}
+
+// A valuePayload is the Payload made by NewValuePayload.  It carries a
+// typed value alongside data and headers maps that start empty.
+type valuePayload[T any] struct {
+	typ   string
+	value T
+	data  map[string]interface{}
+	meta  map[string]interface{}
+}
+
+// A PayloadTyper names the payload type of the values passed to
+// NewValuePayload.
+type PayloadTyper interface {
+	PayloadType() string
+}
+
+// NewValuePayload will create a Payload carrying a strongly typed value,
+// which handlers retrieve with PayloadValue instead of asserting data
+// entries one by one.  The payload type is the result of the value's
+// PayloadType method when T implements PayloadTyper, or else the Go
+// type of the value as printed by %T, such as "orders.Placed" for a
+// value of type Placed in package orders; either way it is what the
+// payload is routed by.  The payload's Data and Meta start as empty
+// maps, so the bus and map-based handlers can still use them.
+func NewValuePayload[T any](value T) Payload {
+	var typ string
+	if typer, ok := any(value).(PayloadTyper); ok {
+		typ = typer.PayloadType()
+	} else {
+		typ = fmt.Sprintf("%T", value)
+	}
+	return &valuePayload[T]{typ, value, map[string]interface{}{}, map[string]interface{}{}}
+}
+
+// Type returns the payload type.
+func (p *valuePayload[T]) Type() string {
+	return p.typ
+}
+
+// Data returns the payload data.
+func (p *valuePayload[T]) Data() map[string]interface{} {
+	return p.data
+}
+
+// Meta returns the payload headers.
+func (p *valuePayload[T]) Meta() map[string]interface{} {
+	return p.meta
+}
+
+// PayloadValue returns the value of a payload made by NewValuePayload
+// with a value of type T, and reports whether p is such a payload.
+func PayloadValue[T any](p Payload) (T, bool) {
+	vp, ok := p.(*valuePayload[T])
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	return vp.value, true
+}
+
+// The JSON encoding of a payload.
+type payloadJSON struct {
+	Type string                 `json:"type"`
+	Data map[string]interface{} `json:"data"`
+	Meta map[string]interface{} `json:"meta,omitempty"`
+}
+
+// MarshalPayload will encode the type and data of a payload as a JSON
+// object of the form {"type": ..., "data": {...}}, encoding nil data
+// as an empty object.  The headers of a MetaPayload, unless empty, are
+// encoded too, under "meta".  Data and header values must be encodable
+// by encoding/json.
+func MarshalPayload(p Payload) ([]byte, error) {
+	if p == nil {
+		message := "Payload error: a nil payload cannot be marshalled."
+		return nil, &busError{time.Now(), message, CodeEmptyPayload, nil}
+	}
+	data := p.Data()
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	var meta map[string]interface{}
+	if mp, ok := p.(MetaPayload); ok {
+		meta = mp.Meta()
+	}
+	b, err := json.Marshal(payloadJSON{p.Type(), data, meta})
+	if err != nil {
+		message := fmt.Sprintf("Payload error: payload with type: %v cannot be marshalled: %v.", p.Type(), err)
+		return nil, &busError{time.Now(), message, CodeInvalidPayload, err}
+	}
+	return b, nil
+}
+
+// UnmarshalPayload will decode a payload encoded by MarshalPayload.
+// Data values come back as encoding/json decodes them into an
+// interface{}, so numbers are float64; GetInt converts them.  The
+// returned payload never has nil data or headers.
+func UnmarshalPayload(b []byte) (Payload, error) {
+	var pj payloadJSON
+	if err := json.Unmarshal(b, &pj); err != nil {
+		message := fmt.Sprintf("Payload error: the payload cannot be unmarshalled: %v.", err)
+		return nil, &busError{time.Now(), message, CodeInvalidPayload, err}
+	}
+	if pj.Type == "" {
+		message := "Payload error: the unmarshalled payload has an empty type."
+		return nil, &busError{time.Now(), message, CodeEmptyPayload, nil}
+	}
+	return NewPayload(pj.Type, pj.Data, pj.Meta), nil
+}
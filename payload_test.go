@@ -0,0 +1,195 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pajato/event"
+)
+
+func TestAcquirePayload(t *testing.T) {
+	p := AcquirePayload("testEventPooled")
+	p.Data()["count"] = 1
+	if p.Type() != "testEventPooled" || len(p.Data()) != 1 {
+		t.Errorf("The acquired payload is wrong: %v %v.", p.Type(), p.Data())
+	}
+	ReleasePayload(p)
+	ReleasePayload(event.New("testEventForeign"))
+	p = AcquirePayload("testEventReused")
+	if p.Type() != "testEventReused" || len(p.Data()) != 0 {
+		t.Errorf("An acquired payload should start empty, but is: %v %v.", p.Type(), p.Data())
+	}
+	data := map[string]interface{}{"count": 1}
+	ReleasePayload(NewPayload("testEventOwned", data, nil))
+	if len(data) != 1 {
+		t.Errorf("Releasing a payload made by NewPayload should leave its data alone, but the data is: %v.", data)
+	}
+}
+
+func TestMarshalPayload(t *testing.T) {
+	e := event.New("testEventMarshal")
+	e.Data()["name"] = "value"
+	e.Data()["count"] = 3
+	e.Data()["tags"] = []interface{}{"a", "b"}
+	b, err := MarshalPayload(e)
+	if err != nil {
+		t.Fatalf("Marshalling failed with message: %v.\n", err)
+	}
+	p, err := UnmarshalPayload(b)
+	if err != nil {
+		t.Fatalf("Unmarshalling failed with message: %v.\n", err)
+	}
+	if p.Type() != "testEventMarshal" {
+		t.Errorf("The type should survive the round trip, but is: %v.", p.Type())
+	}
+	if s, _ := GetString(p, "name"); s != "value" {
+		t.Errorf("The name should survive the round trip, but is: %q.", s)
+	}
+	if n, _ := GetInt(p, "count"); n != 3 {
+		t.Errorf("The count should survive the round trip, but is: %v.", n)
+	}
+	if tags, _ := p.Data()["tags"].([]interface{}); len(tags) != 2 {
+		t.Errorf("The tags should survive the round trip, but are: %v.", p.Data()["tags"])
+	}
+}
+
+func TestMarshalNilData(t *testing.T) {
+	b, err := MarshalPayload(&payload{typ: "testEventNilData"})
+	if err != nil || string(b) != `{"type":"testEventNilData","data":{}}` {
+		t.Errorf("Nil data should marshal as an empty object, but gave: %s, %v.", b, err)
+	}
+	p, err := UnmarshalPayload([]byte(`{"type":"testEventNilData"}`))
+	if err != nil || p.Data() == nil {
+		t.Errorf("Missing data should unmarshal as an empty map, but gave: %v, %v.", p, err)
+	}
+	if _, err := UnmarshalPayload([]byte(`{"data":{}}`)); err == nil {
+		t.Error("Unmarshalling a payload without a type did not fail as expected.")
+	}
+	if _, err := MarshalPayload(nil); err == nil {
+		t.Error("Marshalling a nil payload did not fail as expected.")
+	}
+}
+
+func TestNewPayload(t *testing.T) {
+	p := NewPayload("testEventNewPayload", nil, nil)
+	if p.Type() != "testEventNewPayload" {
+		t.Errorf("The type should be testEventNewPayload, but is: %v.", p.Type())
+	}
+	if p.Data() == nil {
+		t.Fatal("The data of a new payload should never be nil.")
+	}
+	b := New()
+	defer b.Close()
+	var got Payload
+	b.AddHandlers(p.Type(), func(p Payload) error {
+		got = p
+		return nil
+	})
+	p.Data()["k"] = "v"
+	if err := b.PostAndWait(p); err != nil || got != p {
+		t.Errorf("The new payload should be delivered, but the post returned: %v.", err)
+	}
+}
+
+func TestPayloadHeaders(t *testing.T) {
+	b := New(WithSequencing())
+	defer b.Close()
+	name := "query.headers"
+	b.AddHandlers(name, func(p Payload) error {
+		return Reply(p, NewPayload("reply.headers", nil, nil))
+	})
+	q := NewPayload(name, map[string]interface{}{"id": "body"}, map[string]interface{}{"source": "test"})
+	reply, err := b.Request(q, time.Second)
+	if err != nil {
+		t.Fatalf("The request failed with message: %v.\n", err)
+	}
+	if len(q.Data()) != 1 || q.Data()["id"] != "body" {
+		t.Errorf("The bus should leave the data alone, but it is: %v.", q.Data())
+	}
+	id, ok := Header(q, CorrelationIDKey)
+	if !ok || reply.(MetaPayload).Meta()[CorrelationIDKey] != id {
+		t.Errorf("The correlation id should be in the headers of both payloads, but is: %v and %v.", id, reply.(MetaPayload).Meta())
+	}
+	if source, _ := Header(q, "source"); source != "test" || SequenceOf(q) != 1 {
+		t.Errorf("The headers should keep their entries and gain a sequence number, but are: %v.", q.(MetaPayload).Meta())
+	}
+	plain := event.New(name)
+	InjectTrace(ContextWithTrace(context.Background(), Trace{TraceID: "t", SpanID: "s"}), plain)
+	if id, _ := Header(plain, TraceIDKey); id != "t" || plain.Data()[TraceIDKey] != "t" {
+		t.Errorf("A plain payload should carry headers in its data, but has: %v.", plain.Data())
+	}
+	data, err := MarshalPayload(q)
+	if err != nil {
+		t.Fatalf("Marshalling failed with message: %v.\n", err)
+	}
+	p, err := UnmarshalPayload(data)
+	if err != nil {
+		t.Fatalf("Unmarshalling failed with message: %v.\n", err)
+	}
+	if source, _ := Header(p, "source"); source != "test" || p.Data()["id"] != "body" {
+		t.Errorf("The headers and data should survive the round trip, but are: %v and %v.", p.(MetaPayload).Meta(), p.Data())
+	}
+}
+
+type orderPlaced struct {
+	ID     string
+	Amount int
+}
+
+type userCreated struct{ Name string }
+
+func (userCreated) PayloadType() string { return "user.created" }
+
+func TestValuePayload(t *testing.T) {
+	b := New()
+	defer b.Close()
+	p := NewValuePayload(orderPlaced{"o-1", 42})
+	if p.Type() != "bus.orderPlaced" {
+		t.Errorf("The type should be the Go type, but is: %v.", p.Type())
+	}
+	if typ := NewValuePayload(userCreated{"ann"}).Type(); typ != "user.created" {
+		t.Errorf("The type should come from PayloadType, but is: %v.", typ)
+	}
+	var got orderPlaced
+	b.AddHandlers(p.Type(), func(p Payload) error {
+		got, _ = PayloadValue[orderPlaced](p)
+		return nil
+	})
+	if err := b.PostAndWait(p); err != nil || got.ID != "o-1" || got.Amount != 42 {
+		t.Errorf("The handler should receive the typed value, but got: %+v and error: %v.", got, err)
+	}
+	if _, ok := PayloadValue[userCreated](p); ok {
+		t.Error("PayloadValue should fail for a value of another type.")
+	}
+	if _, ok := PayloadValue[orderPlaced](event.New("plain")); ok {
+		t.Error("PayloadValue should fail for a map-based payload.")
+	}
+}
+
+func benchmarkPayloads(b *testing.B, acquire func(typ string) Payload, release func(p Payload)) {
+	bus := New(WithInlineSyncDelivery())
+	defer bus.Close()
+	name := "benchmarkEvent"
+	bus.AddHandlers(name, func(p Payload) error { return nil })
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p := acquire(name)
+		p.Data()["count"] = i
+		bus.PostAndWait(p)
+		release(p)
+	}
+}
+
+func BenchmarkNewPayload(b *testing.B) {
+	benchmarkPayloads(b, func(typ string) Payload { return NewPayload(typ, nil, nil) }, func(p Payload) {})
+}
+
+func BenchmarkAcquirePayload(b *testing.B) {
+	benchmarkPayloads(b, AcquirePayload, ReleasePayload)
+}
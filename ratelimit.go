@@ -0,0 +1,92 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A limiter spaces the deliveries of a payload type at least interval
+// apart, handing out the time slot of each delivery in turn.
+type limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	policy   OverflowPolicy
+	next     time.Time
+}
+
+// SetRateLimit will cap the deliveries of a given payload type, or of
+// the types matching a given wildcard, at perSecond a second, spaced
+// evenly.  The types matching one wildcard share its limit.  Under
+// OverflowBlock a payload over the limit waits for its turn, holding
+// up the goroutine delivering it, while under OverflowDrop it is
+// dropped and logged and under OverflowError it is also reported as an
+// ErrBusFull error.  Dropped payloads are counted in the RateLimited
+// statistic of their type.  A perSecond of zero removes the limit.
+func (b *Bus) SetRateLimit(typ string, perSecond int, policy OverflowPolicy) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if perSecond <= 0 {
+		delete(b.limits, typ)
+		return
+	}
+	b.limits[typ] = &limiter{interval: time.Second / time.Duration(perSecond), policy: policy}
+}
+
+// limiterOf returns the limiter of a payload type or of the most
+// specific wildcard matching it, if any.  The caller must hold the
+// lock.
+func (b *Bus) limiterOf(typ string) *limiter {
+	if l, ok := b.limits[typ]; ok {
+		return l
+	}
+	for i := strings.LastIndex(typ, "."); i >= 0; i = strings.LastIndex(typ[:i], ".") {
+		if l, ok := b.limits[typ[:i+1]+"*"]; ok {
+			return l
+		}
+	}
+	return nil
+}
+
+// throttle waits for the turn of a payload under a limiter and reports
+// whether it may be delivered, with the error for its poster if not.
+func (b *Bus) throttle(ctx context.Context, l *limiter, p Payload) (bool, error) {
+	l.mu.Lock()
+	now := b.clock.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	if wait > 0 && l.policy != OverflowBlock {
+		l.mu.Unlock()
+		atomic.AddUint64(&b.stats.of(p.Type()).limited, 1)
+		if b.logs(LogError) {
+			b.logger.Printf("Warning: dropping payload with type: %v, over its rate limit.\n", p.Type())
+		}
+		if l.policy != OverflowError {
+			return false, nil
+		}
+		message := fmt.Sprintf("Bus full: payload with type: %v is over its rate limit.", p.Type())
+		return false, &busError{b.clock.Now(), message, CodeBusFull, nil}
+	}
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	if wait <= 0 {
+		return true, nil
+	}
+	t := b.clock.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return true, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
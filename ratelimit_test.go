@@ -0,0 +1,78 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pajato/event"
+)
+
+func TestRateLimitDelays(t *testing.T) {
+	c := NewFakeClock(time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC))
+	b := New(WithClock(c), WithAsyncWorkers(1))
+	defer b.Close()
+	name := "testEventRateLimit"
+	b.SetRateLimit(name, 50, OverflowBlock)
+	var mu sync.Mutex
+	var times []time.Time
+	delivered := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(times)
+	}
+	b.AddHandlers(name, func(p Payload) error {
+		mu.Lock()
+		times = append(times, c.Now())
+		mu.Unlock()
+		return nil
+	})
+	go func() {
+		for i := 0; i < 5; i++ {
+			b.Post(event.New(name))
+		}
+	}()
+
+	// Each payload after the first waits for its turn, 20ms apart, on
+	// the only worker.
+	for n := 1; n <= 5; n++ {
+		for i := 0; i < 1000 && delivered() < n; i++ {
+			if c.Timers() > 0 {
+				c.Advance(20 * time.Millisecond)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(times) != 5 {
+		t.Fatalf("All 5 payloads should be delivered, but %v were.", len(times))
+	}
+	for i, tm := range times {
+		if d := tm.Sub(times[0]); d != time.Duration(i)*20*time.Millisecond {
+			t.Errorf("5 deliveries at 50 a second should be 20ms apart, but delivery %v came after %v.", i, d)
+		}
+	}
+}
+
+func TestRateLimitDrops(t *testing.T) {
+	b := New()
+	defer b.Close()
+	name := "testEventRateLimitDrop"
+	b.SetRateLimit(name, 1, OverflowError)
+	b.AddHandlers(name, h1)
+	if err := b.PostAndWait(event.New(name)); err != nil {
+		t.Errorf("The first post failed with message: %v.\n", err)
+	}
+	if err := b.PostAndWait(event.New(name)); !errors.Is(err, ErrBusFull) {
+		t.Errorf("A post over the limit should fail with ErrBusFull, but the error is: %v.", err)
+	}
+	if n := b.Stats().Types[name].RateLimited; n != 1 {
+		t.Errorf("One payload should be rate limited, but the count is: %v.", n)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// WithStrictTypes makes the bus refuse the payload types that were not
+// declared with RegisterType, so that a misspelt type fails while the
+// bus is wired rather than silently never firing.  Posting a payload of
+// an unknown type, or registering handlers for one, fails with
+// ErrUnknownType, while registering a channel for one is logged as an
+// error.  A wildcard is known when it matches a registered type, and
+// the meta-event types and GlobalType are always known.  Strict mode is
+// off by default.
+func WithStrictTypes() Option {
+	return func(b *Bus) {
+		b.strict = true
+	}
+}
+
+// RegisterType declares a payload type as known, for a bus created
+// with WithStrictTypes.  Registering a type twice is harmless.
+func (b *Bus) RegisterType(typ string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.known[typ] = true
+}
+
+// KnownTypes returns the registered payload types in sorted order.
+func (b *Bus) KnownTypes() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	types := make([]string, 0, len(b.known))
+	for typ := range b.known {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// checkType returns an error for a type unknown to a strict bus.  The
+// caller must hold the lock.
+func (b *Bus) checkType(typ string) error {
+	if !b.strict || b.known[typ] || typ == GlobalType || strings.HasPrefix(typ, MetaPrefix) || isMetaWildcard(typ) {
+		return nil
+	}
+	if strings.HasSuffix(typ, ".*") {
+		prefix := strings.TrimSuffix(typ, "*")
+		for known := range b.known {
+			if strings.HasPrefix(known, prefix) {
+				return nil
+			}
+		}
+	}
+	message := fmt.Sprintf("Type error: payload type: %v is not registered.", typ)
+	return &busError{b.clock.Now(), message, CodeUnknownType, nil}
+}
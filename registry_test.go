@@ -0,0 +1,43 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pajato/event"
+)
+
+func TestStrictTypes(t *testing.T) {
+	b := New(WithStrictTypes())
+	defer b.Close()
+	b.RegisterType("user.created")
+	b.RegisterType("user.deleted")
+	if _, err := b.AddHandlers("user.craeted", h1); !errors.Is(err, ErrUnknownType) {
+		t.Errorf("Registering for a misspelt type should fail with ErrUnknownType, but the error is: %v.", err)
+	}
+	if err := b.PostAndWait(event.New("user.craeted")); !errors.Is(err, ErrUnknownType) {
+		t.Errorf("Posting a misspelt type should fail with ErrUnknownType, but the error is: %v.", err)
+	}
+	if _, err := b.AddHandlers("user.*", h1); err != nil {
+		t.Errorf("A wildcard matching a known type should be accepted, but the error is: %v.", err)
+	}
+	if err := b.PostAndWait(event.New("user.created")); err != nil {
+		t.Errorf("Posting a known type failed with message: %v.\n", err)
+	}
+	types := b.KnownTypes()
+	if len(types) != 2 || types[0] != "user.created" || types[1] != "user.deleted" {
+		t.Errorf("The known types should be sorted, but are: %v.", types)
+	}
+}
+
+func TestLenientTypes(t *testing.T) {
+	b := New()
+	defer b.Close()
+	if _, err := b.AddHandlers("anything", h1); err != nil {
+		t.Errorf("Without strict mode any type should be accepted, but the error is: %v.", err)
+	}
+}
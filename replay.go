@@ -0,0 +1,159 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// A replayBuffer is a ring holding the last payloads delivered for a
+// payload type.
+type replayBuffer struct {
+	mu   sync.Mutex
+	ring []Payload
+	next int
+	full bool
+}
+
+// WithReplay makes the bus retain the last n payloads delivered for the
+// payload type typ and replay them, oldest first, to every handler and
+// channel registered for typ itself, not a wildcard, afterwards.  This
+// is the sticky or last-value pattern, for subscribers that start late
+// but need to know the latest state.  Replay runs on a goroutine of its
+// own and the live delivery of payloads to a new subscriber waits until
+// its replay has finished, so a subscriber sees the retained payloads
+// before any live payload and none twice.  A replay invokes a handler
+// as an asynchronous delivery would, through the middleware, group
+// limit and circuit breaker of the handler and counted by Stats, and
+// sends to a channel as a live delivery would, honouring the options
+// of the channel and removing it should it be found closed.  The
+// option may be given once for each type to retain.
+func WithReplay(typ string, n int) Option {
+	return func(b *Bus) {
+		if n <= 0 {
+			return
+		}
+		if b.replays == nil {
+			b.replays = make(map[string]*replayBuffer)
+		}
+		b.replays[typ] = &replayBuffer{ring: make([]Payload, n)}
+	}
+}
+
+// add retains a payload, evicting the oldest one when the ring is full.
+func (rb *replayBuffer) add(p Payload) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.ring[rb.next] = p
+	rb.next = (rb.next + 1) % len(rb.ring)
+	rb.full = rb.full || rb.next == 0
+}
+
+// retained returns the retained payloads, oldest first.
+func (rb *replayBuffer) retained() []Payload {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if !rb.full {
+		return append([]Payload(nil), rb.ring[:rb.next]...)
+	}
+	return append(append([]Payload(nil), rb.ring[rb.next:]...), rb.ring[:rb.next]...)
+}
+
+// retain records a payload being delivered if its routing key, its
+// type unless the bus routes by another key, is retained.
+// The caller must hold the read lock, so that a subscriber registered
+// concurrently either has the payload replayed or delivered live.
+func (b *Bus) retain(key string, p Payload) {
+	if rb := b.replays[key]; rb != nil {
+		rb.add(p)
+	}
+}
+
+// replayHandlers starts the replay of the payloads retained for a type
+// to new handler entries, given the list of entries they join.  The
+// caller must hold the lock and call it before the list is registered.
+func (b *Bus) replayHandlers(typ string, list, entries []*handlerEntry) {
+	rb := b.replays[typ]
+	if rb == nil {
+		return
+	}
+	retained := rb.retained()
+	if len(retained) == 0 {
+		return
+	}
+	middleware := b.cfg.middleware
+	for i, e := range list {
+		for _, n := range entries {
+			if e == n {
+				e.ready = make(chan struct{})
+				go b.replayTo(i, e, retained, middleware)
+			}
+		}
+	}
+}
+
+// replayTo invokes a handler entry, at index i of its list, for each
+// retained payload as an asynchronous delivery would, and then lets
+// live delivery to it proceed.
+func (b *Bus) replayTo(i int, e *handlerEntry, retained []Payload, middleware []Middleware) {
+	defer close(e.ready)
+	for _, p := range retained {
+		if e.once && atomic.LoadInt32(&e.fired) != 0 {
+			return
+		}
+		if b.logs(LogDebug) {
+			b.logger.Printf("Replaying payload with type: %v.\n", p.Type())
+		}
+		r := rider{payload: p, mode: Asynchronous, ctx: context.Background(), posted: b.clock.Now()}
+		b.invokeEntry(r, i, e, middleware, b.stats.of(p.Type()))
+	}
+}
+
+// replayChannel starts the replay of the payloads retained for a type
+// to a new channel entry.  The caller must hold the lock and call it
+// before the entry is registered.
+func (b *Bus) replayChannel(typ string, ce *channelEntry) {
+	rb := b.replays[typ]
+	if rb == nil {
+		return
+	}
+	retained := rb.retained()
+	if len(retained) == 0 {
+		return
+	}
+	ce.ready = make(chan struct{})
+	go func() {
+		defer close(ce.ready)
+		for _, p := range retained {
+			_, err := b.offer(context.Background(), ce, p)
+			if err == errClosedChannel {
+				if b.logs(LogError) {
+					b.logger.Printf("Removing a closed subscriber channel found replaying payload with type: %v.\n", p.Type())
+				}
+				b.prune(ce)
+				return
+			}
+			select {
+			case <-ce.done:
+				return
+			default:
+			}
+		}
+	}()
+}
+
+// awaitReplay waits for the replay signalled by ready, if any, to
+// finish, returning ctx.Err() if ctx is cancelled first or already.
+func awaitReplay(ctx context.Context, ready chan struct{}) error {
+	if ready != nil {
+		select {
+		case <-ready:
+		case <-ctx.Done():
+		}
+	}
+	return ctx.Err()
+}
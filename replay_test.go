@@ -0,0 +1,99 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pajato/event"
+)
+
+func TestReplay(t *testing.T) {
+	name := "config.updated"
+	b := New(WithReplay(name, 2))
+	defer b.Close()
+	post := func(n int) {
+		e := event.New(name)
+		e.Data()["n"] = n
+		b.PostAndWait(e)
+	}
+	for i := 1; i <= 3; i++ {
+		post(i)
+	}
+	c := make(chan Payload, 10)
+	b.AddChannel(name, c)
+	var mu sync.Mutex
+	var seen []interface{}
+	b.AddHandlers(name, func(p Payload) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, p.Data()["n"])
+		return nil
+	})
+	post(4)
+	for _, want := range []int{2, 3, 4} {
+		if n := (<-c).Data()["n"]; n != want {
+			t.Errorf("The channel should receive payload %v, but received: %v.", want, n)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(seen) != 3 || seen[0] != 2 || seen[1] != 3 || seen[2] != 4 {
+		t.Errorf("The handler should see payloads 2, 3 and 4 in order, but saw: %v.", seen)
+	}
+}
+
+func TestReplayClosedChannel(t *testing.T) {
+	name := "config.closed"
+	b := New(WithReplay(name, 4))
+	defer b.Close()
+	b.PostAndWait(event.New(name))
+	b.PostAndWait(event.New(name))
+	c := make(chan Payload)
+	close(c)
+	b.AddChannel(name, c)
+	for i := 0; i < 100 && b.ChannelCount(name) != 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if n := b.ChannelCount(name); n != 0 {
+		t.Errorf("A channel found closed by its replay should be removed, but the count is: %v.", n)
+	}
+	if err := b.PostAndWait(event.New(name)); err != nil {
+		t.Errorf("Posting after the closed channel was removed failed with message: %v.\n", err)
+	}
+}
+
+func TestReplayMiddleware(t *testing.T) {
+	name := "testEventReplayMiddleware"
+	b := New(WithReplay(name, 2))
+	defer b.Close()
+	var mu sync.Mutex
+	wrapped := 0
+	b.Use(func(next Handler) Handler {
+		return func(p Payload) error {
+			mu.Lock()
+			wrapped++
+			mu.Unlock()
+			return next(p)
+		}
+	})
+	b.PostAndWait(NewPayload(name, nil, nil))
+	b.PostAndWait(NewPayload(name, nil, nil))
+
+	// A replay invokes the handler as a live delivery does, through
+	// the middleware and counted by the stats.
+	b.AddHandlers(name, func(p Payload) error { return nil })
+	b.PostAndWait(NewPayload(name, nil, nil))
+	mu.Lock()
+	defer mu.Unlock()
+	if wrapped != 3 {
+		t.Errorf("The middleware should wrap 2 replays and 1 live delivery, but wrapped %v.", wrapped)
+	}
+	if n := b.Stats().Types[name].Succeeded; n != 3 {
+		t.Errorf("The stats should count 3 successful invocations, but counted %v.", n)
+	}
+}
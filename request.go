@@ -0,0 +1,164 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// The keys Request reserves in the headers, or else the data, of a
+// request payload.  The value under ReplyToKey is used by Reply to
+// route the reply back to the requester and the value under
+// CorrelationIDKey is a string identifying the request.
+const (
+	ReplyToKey       = "bus.replyTo"
+	CorrelationIDKey = "bus.correlationID"
+)
+
+// A replyTo, stored in the headers of a request, carries replies back
+// to the requester, accepting at most remaining of them.
+type replyTo struct {
+	replies   chan Payload
+	remaining int32
+}
+
+// Request will post a query payload and wait up to timeout for a reply
+// to it, turning the bus into a lightweight in-process RPC mechanism.
+// The payload is posted asynchronously after a reply channel and a
+// correlation id are stored in its headers, or in its data when it is
+// not a MetaPayload, which must then not be nil; with an enricher,
+// they are stored in the payload the enricher returns.  Responders are
+// ordinary handlers that answer by calling Reply; only the first reply
+// is returned.
+func (b *Bus) Request(p Payload, timeout time.Duration) (Payload, error) {
+	if p == nil {
+		message := "Payload error: a nil payload cannot be requested."
+		return nil, &busError{b.clock.Now(), message, CodeEmptyPayload, nil}
+	}
+	p, err := b.prepare(p)
+	if err != nil {
+		return nil, err
+	}
+	data := headers(p)
+	if data == nil {
+		message := fmt.Sprintf("Argument error: request payload with type: %v has no data.", p.Type())
+		return nil, &busError{b.clock.Now(), message, CodeInvalidArgument, nil}
+	}
+	rt := &replyTo{make(chan Payload, 1), 1}
+	id := strconv.FormatUint(atomic.AddUint64(&b.nextID, 1), 10)
+	data[ReplyToKey] = rt
+	data[CorrelationIDKey] = id
+	if err := b.postPrepared(p); err != nil {
+		return nil, err
+	}
+	t := b.clock.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case reply := <-rt.replies:
+		return reply, nil
+	case <-t.C():
+		message := fmt.Sprintf("Timeout error: no reply to request with type: %v within %v.", p.Type(), timeout)
+		return nil, &busError{b.clock.Now(), message, CodeTimeout, nil}
+	}
+}
+
+// Gather will post a query payload like Request and collect the
+// replies of every responder for up to timeout.  The responders are
+// the handlers the query is delivered to, global handlers included,
+// leaving out those whose filter rejects it or whose circuit breaker
+// is open.  Each responder may Reply zero times or once; Gather
+// returns as soon as the delivery is complete and there is a reply
+// from every responder, or else when timeout expires, with the replies
+// collected so far, in the order they arrived, and a timeout error.
+// This suits discovery, such as asking every module whether it can
+// handle something.  A query delivered to no handler gathers no
+// replies and no error.
+func (b *Bus) Gather(p Payload, timeout time.Duration) ([]Payload, error) {
+	if p == nil {
+		message := "Payload error: a nil payload cannot be gathered."
+		return nil, &busError{b.clock.Now(), message, CodeEmptyPayload, nil}
+	}
+	p, err := b.prepare(p)
+	if err != nil {
+		return nil, err
+	}
+	data := headers(p)
+	if data == nil {
+		message := fmt.Sprintf("Argument error: query payload with type: %v has no data.", p.Type())
+		return nil, &busError{b.clock.Now(), message, CodeInvalidArgument, nil}
+	}
+	b.mu.RLock()
+	entries, subchans := b.match(b.routingKey(p))
+	taps, _ := b.wiretaps(entries, subchans)
+	b.mu.RUnlock()
+	limit := len(entries) + len(taps)
+	if limit == 0 {
+		return nil, nil
+	}
+	rt := &replyTo{make(chan Payload, limit), int32(limit)}
+	data[ReplyToKey] = rt
+	data[CorrelationIDKey] = strconv.FormatUint(atomic.AddUint64(&b.nextID, 1), 10)
+	if b.duplicate(p) {
+		return nil, nil
+	}
+	var results []DeliveryResult
+	r := rider{payload: p, mode: Asynchronous, ctx: context.Background(), done: make(chan error, 1), results: &results}
+	if err := b.send(r); err != nil {
+		return nil, b.revoke(p, err)
+	}
+	t := b.clock.NewTimer(timeout)
+	defer t.Stop()
+
+	// The number of responders is known once the delivery is complete.
+	n := -1
+	done := r.done
+	var replies []Payload
+	for n < 0 || len(replies) < n {
+		select {
+		case reply := <-rt.replies:
+			replies = append(replies, reply)
+		case <-done:
+			done = nil
+			n = 0
+			for _, res := range results {
+				if res.Ran && res.Channel == nil {
+					n++
+				}
+			}
+		case <-t.C():
+			message := fmt.Sprintf("Timeout error: %v of %v responders replied to query with type: %v within %v.", len(replies), n, p.Type(), timeout)
+			if n < 0 {
+				message = fmt.Sprintf("Timeout error: the delivery of query with type: %v did not complete within %v.", p.Type(), timeout)
+			}
+			return replies, &busError{b.clock.Now(), message, CodeTimeout, nil}
+		}
+	}
+	return replies, nil
+}
+
+// Reply will answer the request payload p with reply, copying the
+// request's correlation id into the reply's headers, or its data when
+// it has no headers.  It returns an error if p is not a request or has
+// already been answered.
+func Reply(p Payload, reply Payload) error {
+	rt, ok := headers(p)[ReplyToKey].(*replyTo)
+	if !ok {
+		message := fmt.Sprintf("Argument error: payload with type: %v is not a request.", p.Type())
+		return &busError{time.Now(), message, CodeInvalidArgument, nil}
+	}
+	if atomic.AddInt32(&rt.remaining, -1) < 0 {
+		message := fmt.Sprintf("Reply error: request with type: %v has already been answered.", p.Type())
+		return &busError{time.Now(), message, CodeAlreadyAnswered, nil}
+	}
+	if data := headers(reply); data != nil {
+		data[CorrelationIDKey] = headers(p)[CorrelationIDKey]
+	}
+	rt.replies <- reply
+	return nil
+}
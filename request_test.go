@@ -0,0 +1,125 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pajato/event"
+)
+
+func TestRequest(t *testing.T) {
+	b := New()
+	name := "query.time"
+	b.AddHandlers(name, func(p Payload) error {
+		reply := event.New("reply.time")
+		reply.Data()["answer"] = 42
+		return Reply(p, reply)
+	})
+	b.AddHandlers(name, func(p Payload) error {
+		if err := Reply(p, event.New("reply.late")); err == nil {
+			t.Error("A second reply was accepted.")
+		}
+		return nil
+	})
+	q := event.New(name)
+	reply, err := b.Request(q, time.Second)
+	if err != nil {
+		t.Fatalf("The request failed with message: %v.\n", err)
+	}
+	if reply.Data()["answer"] != 42 {
+		t.Errorf("The reply should carry the answer 42, but carries: %v.", reply.Data()["answer"])
+	}
+	if id := reply.Data()[CorrelationIDKey]; id != q.Data()[CorrelationIDKey] {
+		t.Errorf("The reply has the wrong correlation id: %v.", id)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	b := New()
+	if _, err := b.Request(event.New("query.nobody"), 10*time.Millisecond); err == nil {
+		t.Error("A request without responders did not time out as expected.")
+	}
+	if _, err := b.Request(nil, time.Second); !errors.Is(err, ErrEmptyPayload) {
+		t.Errorf("Requesting a nil payload should fail with ErrEmptyPayload, but returned: %v.", err)
+	}
+	if err := Reply(event.New("query.plain"), event.New("reply")); err == nil {
+		t.Error("Replying to a payload that is not a request did not fail as expected.")
+	}
+}
+
+func TestGather(t *testing.T) {
+	b := New()
+	defer b.Close()
+	name := "query.capable"
+	for i := 0; i < 3; i++ {
+		i := i
+		b.AddHandlers(name, func(p Payload) error {
+			reply := event.New("reply.capable")
+			reply.Data()["responder"] = i
+			return Reply(p, reply)
+		})
+	}
+	replies, err := b.Gather(event.New(name), time.Second)
+	if err != nil {
+		t.Fatalf("Gathering failed with message: %v.\n", err)
+	}
+	if len(replies) != 3 {
+		t.Errorf("3 replies should be gathered, but %v were.", len(replies))
+	}
+	b.AddHandlers(name, h1)
+	replies, err = b.Gather(event.New(name), 20*time.Millisecond)
+	if !errors.Is(err, ErrTimeout) || len(replies) != 3 {
+		t.Errorf("A silent responder should cause a timeout with 3 replies, but got %v replies and error: %v.", len(replies), err)
+	}
+	if _, err := b.Gather(nil, time.Second); !errors.Is(err, ErrEmptyPayload) {
+		t.Errorf("Gathering a nil payload should fail with ErrEmptyPayload, but returned: %v.", err)
+	}
+}
+
+func TestGatherSkippedResponders(t *testing.T) {
+	b := New()
+	defer b.Close()
+	name := "query.skipped"
+	b.AddHandlers(name, func(p Payload) error {
+		return Reply(p, event.New("reply.skipped"))
+	})
+	b.AddFilteredHandler(name, func(p Payload) bool { return false }, h1)
+	b.AddGlobalHandler(func(p Payload) error {
+		if p.Type() != name {
+			return nil
+		}
+		return Reply(p, event.New("reply.global"))
+	})
+	start := time.Now()
+	replies, err := b.Gather(event.New(name), 5*time.Second)
+	if err != nil || len(replies) != 2 {
+		t.Errorf("The handler and the global handler should reply, but got %v replies and error: %v.", len(replies), err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("A filtered responder should not hold up Gather, but it took %v.", elapsed)
+	}
+}
+
+func TestRequestEnriched(t *testing.T) {
+	b := New(WithPayloadEnricher(func(p Payload) Payload {
+		return NewPayload(p.Type(), nil, nil)
+	}))
+	defer b.Close()
+	name := "query.enriched"
+	b.AddHandlers(name, func(p Payload) error {
+		return Reply(p, event.New("reply.enriched"))
+	})
+
+	// The reply headers belong in the payload the enricher returns.
+	if _, err := b.Request(event.New(name), time.Second); err != nil {
+		t.Errorf("Requesting through an enricher failed with message: %v.\n", err)
+	}
+	if replies, err := b.Gather(event.New(name), time.Second); err != nil || len(replies) != 1 {
+		t.Errorf("Gathering through an enricher should get 1 reply, but got %v replies and error: %v.", len(replies), err)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import (
+	"context"
+	"time"
+)
+
+// A DeliveryResult describes what became of one handler or subscriber
+// channel during a delivery made by Deliver.  For a handler, Channel is
+// nil and Index is the position of the handler in delivery order,
+// which is priority order and then registration order, with the
+// handlers of matching wildcards after those of the payload type.  A
+// handler that was skipped, because its filter rejected the payload or
+// because it was a once handler that had already fired, did not run
+// and has no error or duration.  For a channel, Channel is the channel
+// and Index its position among the channels matching the payload; Ran
+// reports whether the channel accepted the payload, within its send
+// timeout if it has one, and Duration how long the send took.  A
+// channel that did not accept the payload has the overflow or context
+// error of the send, if any, in Err.
+type DeliveryResult struct {
+	Index    int
+	Ran      bool
+	Err      error
+	Duration time.Duration
+	Channel  <-chan Payload
+}
+
+// Deliver will synchronously notify all subscribers like PostAndWait
+// and return the result of every handler matching the payload, followed
+// by that of every subscriber channel, making it easy to tell which
+// handlers of a long pipeline failed and which subsystems got the
+// payload.  If the payload cannot be posted, for example because the
+// bus is closed, or is dropped as a duplicate, Deliver returns nil.
+func (b *Bus) Deliver(p Payload) []DeliveryResult {
+	results, err := b.deliverResults(p)
+	if err != nil {
+		if b.logs(LogError) {
+			b.logger.Printf("Delivery rejected: %v.\n", err)
+		}
+		return nil
+	}
+	return results
+}
+
+// PostAndReduce will synchronously notify all subscribers like
+// PostAndWait and return what reduce makes of the outcomes of the
+// handlers that ran, one error, nil for success, per handler in
+// delivery order.  This lets the poster decide what the handlers
+// together mean, for instance that an authorization is denied when
+// any handler denies it.  A nil reduce selects FailFast.  An error
+// posting the payload is returned as is.
+func (b *Bus) PostAndReduce(p Payload, reduce func(results []error) error) error {
+	if reduce == nil {
+		reduce = FailFast
+	}
+	results, err := b.deliverResults(p)
+	if err != nil {
+		return err
+	}
+	errs := make([]error, 0, len(results))
+	for _, r := range results {
+		if r.Ran && r.Channel == nil {
+			errs = append(errs, r.Err)
+		}
+	}
+	return reduce(errs)
+}
+
+// FailFast is a reducer for PostAndReduce returning the first handler
+// error, if any.
+func FailFast(results []error) error {
+	for _, err := range results {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AllMustSucceed is a reducer for PostAndReduce returning a MultiError
+// holding every handler error, if any, so that the poster sees every
+// failure.
+func AllMustSucceed(results []error) error {
+	var errs MultiError
+	for _, err := range results {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// deliverResults delivers a payload synchronously and returns the
+// result of every handler matching it.
+func (b *Bus) deliverResults(p Payload) ([]DeliveryResult, error) {
+	p, err := b.prepare(p)
+	if err != nil || b.duplicate(p) {
+		return nil, err
+	}
+	if b.logs(LogDebug) {
+		b.logger.Printf("Delivering payload of type: %v.\n", p.Type())
+	}
+	var results []DeliveryResult
+	r := rider{payload: p, mode: Synchronous, ctx: context.Background(), done: make(chan error, 1), results: &results}
+	if err := b.send(r); err != nil {
+		return nil, b.revoke(p, err)
+	}
+	if err := <-r.done; refused(err) {
+		return nil, b.revoke(p, err)
+	}
+	return results, nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pajato/event"
+)
+
+func TestDeliver(t *testing.T) {
+	b := New()
+	name := "testEventDeliver"
+	failure := errors.New("failure")
+	slow := func(p Payload) error {
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}
+	b.AddHandlers(name, h1, failWith(failure), slow)
+	b.AddFilteredHandler(name, func(p Payload) bool { return false }, h2)
+	c := make(chan Payload, 1)
+	b.AddChannel(name, c)
+	results := b.Deliver(event.New(name))
+	if len(results) != 5 {
+		t.Fatalf("There should be 5 results, but there are: %v.", len(results))
+	}
+	for i, r := range results[:4] {
+		if r.Index != i {
+			t.Errorf("The result at %v should have index %v, but has: %v.", i, i, r.Index)
+		}
+	}
+	if !results[0].Ran || results[0].Err != nil {
+		t.Errorf("The first handler should have run without error, but the result is: %+v.", results[0])
+	}
+	if !errors.Is(results[1].Err, failure) {
+		t.Errorf("The second handler should have failed, but the result is: %+v.", results[1])
+	}
+	if results[2].Duration < 10*time.Millisecond {
+		t.Errorf("The third handler should take at least 10ms, but took: %v.", results[2].Duration)
+	}
+	if results[3].Ran {
+		t.Error("The filtered handler should not have run.")
+	}
+	if r := results[4]; r.Channel != c || r.Index != 0 || !r.Ran || r.Err != nil {
+		t.Errorf("The channel should have accepted the payload, but the result is: %+v.", r)
+	}
+	select {
+	case <-c:
+	default:
+		t.Error("The channel subscriber did not receive the payload.")
+	}
+	b.Close()
+	if results := b.Deliver(event.New(name)); results != nil {
+		t.Errorf("Delivering to a closed bus should return nil, but returned: %v.", results)
+	}
+}
+
+func TestDeliverChannelTimeout(t *testing.T) {
+	b := New()
+	defer b.Close()
+	name := "testEventDeliverChannelTimeout"
+	live := make(chan Payload, 1)
+	blocked := make(chan Payload)
+	b.AddChannel(name, live)
+	b.AddChannelWithOptions(name, blocked, ChannelOptions{SendTimeout: 10 * time.Millisecond, Overflow: OverflowError})
+	results := b.Deliver(event.New(name))
+	if len(results) != 2 {
+		t.Fatalf("There should be 2 results, but there are: %v.", len(results))
+	}
+	if r := results[0]; r.Channel != live || !r.Ran {
+		t.Errorf("The live channel should have accepted the payload, but the result is: %+v.", r)
+	}
+	if r := results[1]; r.Channel != blocked || r.Ran || !errors.Is(r.Err, ErrChannelOverflow) {
+		t.Errorf("The blocked channel should have timed out, but the result is: %+v.", r)
+	}
+}
+
+func TestPostAndReduce(t *testing.T) {
+	b := New()
+	defer b.Close()
+	name := "authorize"
+	deny := errors.New("deny")
+	other := errors.New("other")
+	b.AddHandlers(name, h1, failWith(deny), failWith(other))
+	if err := b.PostAndReduce(event.New(name), nil); err != deny {
+		t.Errorf("The default reducer should return the first error, but returned: %v.", err)
+	}
+	err := b.PostAndReduce(event.New(name), AllMustSucceed)
+	if !errors.Is(err, deny) || !errors.Is(err, other) {
+		t.Errorf("AllMustSucceed should return every error, but returned: %v.", err)
+	}
+	anySucceeded := func(results []error) error {
+		for _, err := range results {
+			if err == nil {
+				return nil
+			}
+		}
+		return deny
+	}
+	if err := b.PostAndReduce(event.New(name), anySucceeded); err != nil {
+		t.Errorf("A custom reducer should see the successful handler, but returned: %v.", err)
+	}
+	b.Close()
+	if err := b.PostAndReduce(event.New(name), nil); !errors.Is(err, ErrBusClosed) {
+		t.Errorf("Posting to a closed bus should fail, but returned: %v.", err)
+	}
+}
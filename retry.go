@@ -0,0 +1,74 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// WithRetry makes the bus invoke a failing handler again, up to
+// maxAttempts invocations in all, waiting backoff(attempt) before the
+// retry following the given failed attempt, the first being 1.  Only
+// the failing handler is retried, not the handlers that succeeded, and
+// the payload goes on to the next handler once the handler succeeds or
+// the attempts run out.  A handler still failing after the last
+// attempt sends the payload to the dead letter handler and its last
+// error is the one reported.  A retry returning ErrStopPropagation
+// succeeds and stops propagation, as a first attempt returning it
+// would.  Retries count toward the delivery that Wait and Drain wait
+// for, and stop when the bus closes or the context of the post is
+// cancelled.  A nil backoff retries at once.
+func WithRetry(maxAttempts int, backoff func(attempt int) time.Duration) Option {
+	return func(b *Bus) {
+		b.attempts = maxAttempts
+		b.backoff = backoff
+	}
+}
+
+// retry invokes the handler of an entry again until it succeeds or the
+// attempts run out, and returns its last error.
+func (b *Bus) retry(r rider, e *handlerEntry, h Handler, err error, tc *typeCounters) error {
+	for attempt := 1; attempt < b.attempts; attempt++ {
+		if b.backoff != nil {
+			t := b.clock.NewTimer(b.backoff(attempt))
+			select {
+			case <-t.C():
+			case <-r.ctx.Done():
+				t.Stop()
+				return err
+			case <-b.quit:
+				t.Stop()
+				return err
+			}
+		}
+		if b.logs(LogInfo) {
+			b.logger.Printf("Retrying a handler for payload with type: %v after attempt %v failed: %v.\n", r.payload.Type(), attempt, err)
+		}
+		call := h
+		if e.sem != nil {
+			var aerr error
+			if call, aerr = b.acquire(r.ctx, e.sem, h); aerr != nil {
+				return err
+			}
+		}
+		atomic.AddUint64(&tc.retried, 1)
+		if err = b.call(call, r.payload); err == nil || errors.Is(err, ErrStopPropagation) {
+			return err
+		}
+	}
+	atomic.AddUint64(&tc.gaveUp, 1)
+	if b.logs(LogError) {
+		b.logger.Printf("Giving up on a handler for payload with type: %v after %v attempts.\n", r.payload.Type(), b.attempts)
+	}
+	b.mu.RLock()
+	deadLetter := b.cfg.deadLetter
+	b.mu.RUnlock()
+	if deadLetter != nil {
+		deadLetter(r.payload)
+	}
+	return err
+}
@@ -0,0 +1,79 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pajato/event"
+)
+
+func TestRetry(t *testing.T) {
+	b := New(WithRetry(3, func(attempt int) time.Duration { return time.Millisecond }))
+	defer b.Close()
+	name := "testEventRetry"
+	var good, flaky, broken, dead int32
+	b.AddHandlers(name, func(p Payload) error {
+		atomic.AddInt32(&good, 1)
+		return nil
+	}, func(p Payload) error {
+		if atomic.AddInt32(&flaky, 1) < 2 {
+			return errors.New("flaky")
+		}
+		return nil
+	})
+	b.SetDeadLetterHandler(func(p Payload) { atomic.AddInt32(&dead, 1) })
+	if err := b.PostAndWait(event.New(name)); err != nil {
+		t.Errorf("The retried post failed with message: %v.\n", err)
+	}
+	if good, flaky := atomic.LoadInt32(&good), atomic.LoadInt32(&flaky); good != 1 || flaky != 2 {
+		t.Errorf("Only the failing handler should be retried, but the counts are: %v and %v.", good, flaky)
+	}
+	b.AddHandlers(name, func(p Payload) error {
+		atomic.AddInt32(&broken, 1)
+		return errors.New("broken")
+	})
+	if err := b.PostAndWait(event.New(name)); err == nil {
+		t.Error("A handler failing every attempt did not fail the post.")
+	}
+	if n := atomic.LoadInt32(&broken); n != 3 {
+		t.Errorf("The broken handler should be invoked 3 times, but was invoked %v times.", n)
+	}
+	if n := atomic.LoadInt32(&dead); n != 1 {
+		t.Errorf("The payload should go to the dead letter handler once, but went %v times.", n)
+	}
+	if ts := b.Stats().Types[name]; ts.Retried != 3 || ts.GaveUp != 1 {
+		t.Errorf("There should be 3 retries and 1 give up, but the counts are: %v and %v.", ts.Retried, ts.GaveUp)
+	}
+}
+
+func TestRetryStopPropagation(t *testing.T) {
+	b := New(WithRetry(3, nil))
+	defer b.Close()
+	name := "testEventRetryStop"
+	var attempts, after, dead int32
+	b.AddHandlers(name, func(p Payload) error {
+		if atomic.AddInt32(&attempts, 1) < 2 {
+			return errors.New("flaky")
+		}
+		return ErrStopPropagation
+	}, func(p Payload) error {
+		atomic.AddInt32(&after, 1)
+		return nil
+	})
+	b.SetDeadLetterHandler(func(p Payload) { atomic.AddInt32(&dead, 1) })
+	if err := b.PostAndWait(event.New(name)); err != nil {
+		t.Errorf("A retry stopping propagation should succeed, but the post failed with message: %v.\n", err)
+	}
+	if n, m := atomic.LoadInt32(&attempts), atomic.LoadInt32(&after); n != 2 || m != 0 {
+		t.Errorf("The handler should run twice and stop the next, but the counts are: %v and %v.", n, m)
+	}
+	if ts := b.Stats().Types[name]; ts.GaveUp != 0 || atomic.LoadInt32(&dead) != 0 {
+		t.Errorf("Stopping propagation is not giving up, but %v give ups were counted.", ts.GaveUp)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A schedule tracks the timers of scheduled posts, by id, so that Close
+// can stop them.  A nil set of timers means the bus has closed.
+type schedule struct {
+	sync.Mutex
+	timers map[uint64]Timer
+}
+
+// PostAfter will post a payload asynchronously, as Post does, once d
+// has elapsed and return a function that cancels the post.  Cancelling
+// after the post has been made has no effect, and calling the cancel
+// function more than once is harmless.  Close cancels every scheduled
+// post still pending.  A payload that is nil or has an empty type, or a
+// closed bus, is reported at once; the error of the post itself, if
+// any, is logged when it is made.
+func (b *Bus) PostAfter(d time.Duration, p Payload) (cancel func(), err error) {
+	if err := b.validate(p); err != nil {
+		return nil, err
+	}
+	b.sched.Lock()
+	defer b.sched.Unlock()
+	if b.sched.timers == nil {
+		return nil, b.closedError()
+	}
+	id := atomic.AddUint64(&b.nextID, 1)
+	b.sched.timers[id] = b.clock.AfterFunc(d, func() {
+		if b.unschedule(id) == nil {
+			return
+		}
+		if err := b.Post(p); err != nil {
+			if b.logs(LogError) {
+				b.logger.Printf("Scheduled post of payload with type: %v failed: %v.\n", p.Type(), err)
+			}
+		}
+	})
+	return func() {
+		if t := b.unschedule(id); t != nil {
+			t.Stop()
+		}
+	}, nil
+}
+
+// unschedule forgets the timer with the given id, returning it if it
+// was still scheduled or else nil.
+func (b *Bus) unschedule(id uint64) Timer {
+	b.sched.Lock()
+	defer b.sched.Unlock()
+	t := b.sched.timers[id]
+	delete(b.sched.timers, id)
+	return t
+}
+
+// stopTimers stops every scheduled post and prevents new ones.
+func (b *Bus) stopTimers() {
+	b.sched.Lock()
+	defer b.sched.Unlock()
+	for _, t := range b.sched.timers {
+		t.Stop()
+	}
+	b.sched.timers = nil
+}
+
+// PostEvery will post a fresh payload made by factory asynchronously,
+// as Post does, every d until the returned stop function is called or
+// the bus is closed.  Once stop returns no more payloads are posted and
+// the goroutine driving the posts has exited, so stop must not be
+// called from a handler invoked synchronously.  Calling stop more than
+// once is harmless.  A failing post, such as one of a nil payload, is
+// logged and the next tick tried.
+func (b *Bus) PostEvery(d time.Duration, factory func() Payload) (stop func()) {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		ticker := b.clock.NewTicker(d)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+			case <-done:
+				return
+			case <-b.quit:
+				return
+			}
+			if err := b.Post(factory()); err != nil {
+				if b.logs(LogError) {
+					b.logger.Printf("Recurring post failed: %v.\n", err)
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-exited
+		})
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pajato/event"
+)
+
+func TestPostAfter(t *testing.T) {
+	b := New()
+	name := "testEventPostAfter"
+	c := make(chan Payload, 3)
+	b.AddChannel(name, c)
+	start := time.Now()
+	if _, err := b.PostAfter(20*time.Millisecond, event.New(name)); err != nil {
+		t.Errorf("Scheduling a post failed with message: %v.\n", err)
+	}
+	cancel, _ := b.PostAfter(20*time.Millisecond, event.New(name))
+	cancel()
+	cancel()
+	<-c
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("The scheduled post should be made after 20ms, but was made after: %v.", d)
+	}
+	b.PostAfter(20*time.Millisecond, event.New(name))
+	b.Close()
+	time.Sleep(40 * time.Millisecond)
+	if n := len(c); n != 0 {
+		t.Errorf("Cancelled posts should not be made, but %v were.", n)
+	}
+	if _, err := b.PostAfter(time.Millisecond, event.New(name)); !errors.Is(err, ErrBusClosed) {
+		t.Errorf("Scheduling a post on a closed bus should fail, but returned: %v.", err)
+	}
+}
+
+func TestPostEvery(t *testing.T) {
+	b := New()
+	defer b.Close()
+	name := "testEventPostEvery"
+	c := make(chan Payload, 10)
+	b.AddChannel(name, c)
+	n := 0
+	stop := b.PostEvery(5*time.Millisecond, func() Payload {
+		n++
+		e := event.New(name)
+		e.Data()["tick"] = n
+		return e
+	})
+	for i := 1; i <= 3; i++ {
+		if tick := (<-c).Data()["tick"]; tick != i {
+			t.Errorf("Tick %v should carry a fresh payload, but carries: %v.", i, tick)
+		}
+	}
+	stop()
+	stop()
+	b.Wait()
+	pending := len(c)
+	time.Sleep(20 * time.Millisecond)
+	if len(c) != pending {
+		t.Errorf("No payload should be posted after stopping, but %v were.", len(c)-pending)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import "sync/atomic"
+
+// SequenceKey is the key WithSequencing reserves in the headers, or
+// else the data, of a payload for its sequence number.
+const SequenceKey = "bus.sequence"
+
+// WithSequencing makes the bus stamp each posted payload with a
+// sequence number, which handlers and channel subscribers read with
+// SequenceOf to detect gaps or reordering.  The numbers start at 1 and
+// increase by one with every post accepted by the bus, whatever its
+// kind, so they are consistent across synchronous and asynchronous
+// posts, and follow the order in which the posts are queued.  Posts
+// the bus refuses, such as a TryPost to a full bus, and duplicate
+// posts are not numbered, nor is each post of a coalesced burst, which
+// is numbered once, when it is delivered.  A payload posted again is
+// stamped again, so it must not be reposted while a previous delivery
+// of it may still read its headers.  Sequencing is off by default.
+func WithSequencing() Option {
+	return func(b *Bus) {
+		b.sequenced = true
+	}
+}
+
+// SequenceOf returns the sequence number stamped on p by a bus created
+// with WithSequencing, or 0 if it has none.
+func SequenceOf(p Payload) uint64 {
+	n, _ := headers(p)[SequenceKey].(uint64)
+	return n
+}
+
+// stamp stores the next sequence numbers in the headers of the
+// payloads of r.  It does nothing unless the bus sequences payloads,
+// and skips the payloads that have neither headers nor data.  The
+// caller must hold the stamping lock from the admission of r until it
+// is queued or refused, so that the numbers follow the order in which
+// the posts are queued.
+func (b *Bus) stamp(r rider) {
+	if !b.sequenced {
+		return
+	}
+	for _, m := range r.members() {
+		if data := headers(m.payload); data != nil {
+			data[SequenceKey] = atomic.AddUint64(&b.sequence, 1)
+		}
+	}
+}
+
+// unstamp gives back the sequence numbers stamp gave the payloads of a
+// rider the bus then refused, so that refused posts leave no gap.  The
+// caller must hold the stamping lock taken for stamp.
+func (b *Bus) unstamp(r rider) {
+	if !b.sequenced {
+		return
+	}
+	for _, m := range r.members() {
+		if data := headers(m.payload); data != nil {
+			delete(data, SequenceKey)
+			atomic.AddUint64(&b.sequence, ^uint64(0))
+		}
+	}
+}
+
+// stampNow stamps a rider that is delivered without being queued.
+func (b *Bus) stampNow(r rider) {
+	if !b.sequenced {
+		return
+	}
+	b.stamping.Lock()
+	defer b.stamping.Unlock()
+	b.stamp(r)
+}
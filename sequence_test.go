@@ -0,0 +1,61 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"testing"
+
+	"github.com/pajato/event"
+)
+
+func TestSequencing(t *testing.T) {
+	b := New(WithSequencing())
+	defer b.Close()
+	name := "testEventSequencing"
+	var seen []uint64
+	b.AddHandlers(name, func(p Payload) error {
+		seen = append(seen, SequenceOf(p))
+		return nil
+	})
+	for i := 0; i < 6; i++ {
+		if i%2 == 0 {
+			b.PostAndWait(event.New(name))
+		} else {
+			b.Post(event.New(name))
+			b.Wait()
+		}
+	}
+	if len(seen) != 6 {
+		t.Fatalf("Every payload should be delivered, but %v were.", len(seen))
+	}
+	for i, n := range seen {
+		if n != uint64(i+1) {
+			t.Errorf("The sequence numbers should increase by one from 1, but are: %v.", seen)
+			break
+		}
+	}
+	if n := SequenceOf(event.New(name)); n != 0 {
+		t.Errorf("An unposted payload should have no sequence number, but has: %v.", n)
+	}
+}
+
+func TestSequencingRefusedPost(t *testing.T) {
+	b := New(WithPubChanBuffer(1), WithAsyncWorkers(1), WithSequencing())
+	defer b.Close()
+	name := "testEventSequencingRefused"
+	release := fill(b)
+	refused := event.New(name)
+	if b.TryPost(refused) {
+		t.Fatal("A post to a full bus should be refused.")
+	}
+	release()
+	b.Wait()
+	p := event.New(name)
+	b.PostAndWait(p)
+	filled := b.Stats().Types["testEventFill"].Posted
+	if n := SequenceOf(p); n != filled+1 || SequenceOf(refused) != 0 {
+		t.Errorf("The refused post should leave no gap, but the next post is number %v after %v.", n, filled)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TypeStats holds the delivery counters of one payload type.  Latency
+// is measured from the moment a payload is posted to the moment its
+// delivery to every handler and channel has finished.
+type TypeStats struct {
+	Posted       uint64
+	Delivered    uint64
+	Succeeded    uint64
+	Failed       uint64
+	Deduplicated uint64
+	Repeated     uint64
+	RateLimited  uint64
+	Retried      uint64
+	GaveUp       uint64
+	Tripped      int
+	Slow         uint64
+	SlowestIndex int
+	Slowest      time.Duration
+	TotalLatency time.Duration
+	MaxLatency   time.Duration
+}
+
+// MeanLatency returns the average delivery latency, or zero when
+// nothing has been delivered.
+func (s TypeStats) MeanLatency() time.Duration {
+	if s.Delivered == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Delivered)
+}
+
+// Stats is a snapshot of the delivery counters of a bus, keyed by
+// payload type.  Succeeded and Failed count handler invocations while
+// Posted and Delivered count payloads.  Deduplicated counts the posts
+// dropped as duplicates by a bus created with WithDedup, Repeated
+// those dropped as repeats by a bus created with WithIdempotency,
+// RateLimited the payloads dropped over the rate limit set by
+// SetRateLimit and Tripped the handlers whose circuit breaker, set up
+// by WithCircuitBreaker, is currently tripped.  Under WithRetry,
+// Retried counts the retried invocations and GaveUp the invocations
+// that still failed after the last attempt.  Under
+// WithSlowHandlerThreshold, Slow counts the handler invocations that
+// ran longer than the threshold and Slowest is the longest invocation,
+// by the handler at SlowestIndex.  QueueDepth is the number
+// of posted payloads waiting for the bus goroutine and QueueCapacity
+// the size of the buffer they wait in.
+type Stats struct {
+	Types         map[string]TypeStats
+	QueueDepth    int
+	QueueCapacity int
+}
+
+// A typeCounters holds the live, atomically updated, counters of one
+// payload type.
+type typeCounters struct {
+	posted, delivered, succeeded, failed, deduplicated, limited uint64
+	retried, gaveUp, repeated, slow                             uint64
+	totalLatency, maxLatency                                    int64
+
+	// The longest handler invocation, guarded by mu.
+	mu           sync.Mutex
+	slowest      time.Duration
+	slowestIndex int
+}
+
+// The counters type holds the typeCounters of every payload type seen
+// by a bus.
+type counters struct {
+	mu    sync.Mutex
+	types map[string]*typeCounters
+}
+
+func newCounters() *counters {
+	return &counters{types: make(map[string]*typeCounters)}
+}
+
+// of returns the counters for a payload type, creating them as needed.
+func (c *counters) of(typ string) *typeCounters {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tc, ok := c.types[typ]
+	if !ok {
+		tc = new(typeCounters)
+		c.types[typ] = tc
+	}
+	return tc
+}
+
+// record records the delivery of a payload posted at the given time
+// as completed now.
+func (tc *typeCounters) record(posted, now time.Time) {
+	latency := int64(now.Sub(posted))
+	atomic.AddUint64(&tc.delivered, 1)
+	atomic.AddInt64(&tc.totalLatency, latency)
+	for {
+		max := atomic.LoadInt64(&tc.maxLatency)
+		if latency <= max || atomic.CompareAndSwapInt64(&tc.maxLatency, max, latency) {
+			return
+		}
+	}
+}
+
+// timeHandler records the duration of an invocation of the handler at
+// the given index, keeping the longest.
+func (tc *typeCounters) timeHandler(index int, d time.Duration) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if d > tc.slowest {
+		tc.slowest, tc.slowestIndex = d, index
+	}
+}
+
+// Stats returns a snapshot of the delivery counters of every payload
+// type posted to the bus so far.
+func (b *Bus) Stats() Stats {
+	tripped := make(map[string]int)
+	b.mu.RLock()
+	for typ, entries := range b.handlers {
+		for _, e := range entries {
+			if e.breaker.tripped() {
+				tripped[typ]++
+			}
+		}
+	}
+	b.mu.RUnlock()
+	b.stats.mu.Lock()
+	defer b.stats.mu.Unlock()
+	s := Stats{make(map[string]TypeStats, len(b.stats.types)), len(b.pubchan), cap(b.pubchan)}
+	for typ, tc := range b.stats.types {
+		tc.mu.Lock()
+		slowest, slowestIndex := tc.slowest, tc.slowestIndex
+		tc.mu.Unlock()
+		s.Types[typ] = TypeStats{
+			Posted:       atomic.LoadUint64(&tc.posted),
+			Delivered:    atomic.LoadUint64(&tc.delivered),
+			Succeeded:    atomic.LoadUint64(&tc.succeeded),
+			Failed:       atomic.LoadUint64(&tc.failed),
+			Deduplicated: atomic.LoadUint64(&tc.deduplicated),
+			Repeated:     atomic.LoadUint64(&tc.repeated),
+			RateLimited:  atomic.LoadUint64(&tc.limited),
+			Retried:      atomic.LoadUint64(&tc.retried),
+			GaveUp:       atomic.LoadUint64(&tc.gaveUp),
+			Slow:         atomic.LoadUint64(&tc.slow),
+			SlowestIndex: slowestIndex,
+			Slowest:      slowest,
+			TotalLatency: time.Duration(atomic.LoadInt64(&tc.totalLatency)),
+			MaxLatency:   time.Duration(atomic.LoadInt64(&tc.maxLatency)),
+		}
+	}
+	for typ, n := range tripped {
+		ts := s.Types[typ]
+		ts.Tripped = n
+		s.Types[typ] = ts
+	}
+	return s
+}
+
+// WritePrometheus will write the snapshot to w in the Prometheus text
+// exposition format, so that a metrics endpoint can serve it as is.
+func (s Stats) WritePrometheus(w io.Writer) error {
+	types := make([]string, 0, len(s.Types))
+	for typ := range s.Types {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	metrics := []struct {
+		name, help, kind string
+		value            func(ts TypeStats) float64
+	}{
+		{"bus_payloads_posted_total", "Payloads posted to the bus.", "counter",
+			func(ts TypeStats) float64 { return float64(ts.Posted) }},
+		{"bus_payloads_delivered_total", "Payloads whose delivery has completed.", "counter",
+			func(ts TypeStats) float64 { return float64(ts.Delivered) }},
+		{"bus_handler_successes_total", "Handler invocations that succeeded.", "counter",
+			func(ts TypeStats) float64 { return float64(ts.Succeeded) }},
+		{"bus_handler_failures_total", "Handler invocations that failed.", "counter",
+			func(ts TypeStats) float64 { return float64(ts.Failed) }},
+		{"bus_payloads_deduplicated_total", "Posts dropped as duplicates.", "counter",
+			func(ts TypeStats) float64 { return float64(ts.Deduplicated) }},
+		{"bus_payloads_repeated_total", "Posts dropped as repeats of an idempotency key.", "counter",
+			func(ts TypeStats) float64 { return float64(ts.Repeated) }},
+		{"bus_payloads_rate_limited_total", "Payloads dropped over their rate limit.", "counter",
+			func(ts TypeStats) float64 { return float64(ts.RateLimited) }},
+		{"bus_handler_retries_total", "Handler invocations retried.", "counter",
+			func(ts TypeStats) float64 { return float64(ts.Retried) }},
+		{"bus_handler_give_ups_total", "Handler invocations failing after the last retry.", "counter",
+			func(ts TypeStats) float64 { return float64(ts.GaveUp) }},
+		{"bus_handler_slow_total", "Handler invocations over the slow handler threshold.", "counter",
+			func(ts TypeStats) float64 { return float64(ts.Slow) }},
+		{"bus_handler_duration_seconds_max", "Longest handler invocation.", "gauge",
+			func(ts TypeStats) float64 { return ts.Slowest.Seconds() }},
+		{"bus_handlers_tripped", "Handlers whose circuit breaker is tripped.", "gauge",
+			func(ts TypeStats) float64 { return float64(ts.Tripped) }},
+		{"bus_delivery_latency_seconds_total", "Summed latency from post to completed delivery.", "counter",
+			func(ts TypeStats) float64 { return ts.TotalLatency.Seconds() }},
+		{"bus_delivery_latency_seconds_max", "Largest latency from post to completed delivery.", "gauge",
+			func(ts TypeStats) float64 { return ts.MaxLatency.Seconds() }},
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	for _, m := range metrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind); err != nil {
+			return err
+		}
+		for _, typ := range types {
+			if _, err := fmt.Fprintf(w, "%s{type=\"%s\"} %v\n", m.name, escaper.Replace(typ), m.value(s.Types[typ])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
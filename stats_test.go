@@ -0,0 +1,46 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pajato/event"
+)
+
+func TestStats(t *testing.T) {
+	b := New()
+	name := "testEventStats"
+	b.AddHandlers(name, h1, failWith(errors.New("failure")))
+	for i := 0; i < 3; i++ {
+		b.PostAndWait(event.New(name))
+	}
+	ts := b.Stats().Types[name]
+	if ts.Posted != 3 || ts.Delivered != 3 {
+		t.Errorf("3 payloads should be posted and delivered, but the counts are: %v and %v.", ts.Posted, ts.Delivered)
+	}
+	if ts.Succeeded != 3 || ts.Failed != 3 {
+		t.Errorf("3 invocations each should succeed and fail, but the counts are: %v and %v.", ts.Succeeded, ts.Failed)
+	}
+	if ts.MaxLatency <= 0 || ts.MeanLatency() > ts.MaxLatency {
+		t.Errorf("The latencies are inconsistent: mean %v, max %v.", ts.MeanLatency(), ts.MaxLatency)
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	b := New()
+	b.PostAndWait(event.New(`odd"type`))
+	var buf bytes.Buffer
+	if err := b.Stats().WritePrometheus(&buf); err != nil {
+		t.Fatalf("WritePrometheus failed with message: %v.\n", err)
+	}
+	want := `bus_payloads_posted_total{type="odd\"type"} 1`
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("The exposition should contain %q, but is: %q.", want, buf.String())
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// The keys InjectTrace reserves in the headers, or else the data, of a
+// payload.  The values are the hex encoded trace and span ids of the
+// publisher's span.
+const (
+	TraceIDKey = "bus.traceID"
+	SpanIDKey  = "bus.spanID"
+)
+
+// A Trace identifies a span of a distributed trace.  Its ids are hex
+// strings using the W3C trace context sizes, so they convert directly
+// to and from those of tracing libraries such as OpenTelemetry.
+// ParentID is the span id of the parent span, if any, and Name the
+// name of the span.
+type Trace struct {
+	TraceID  string
+	SpanID   string
+	ParentID string
+	Name     string
+}
+
+type traceKey struct{}
+
+// ContextWithTrace returns a copy of ctx carrying t.
+func ContextWithTrace(ctx context.Context, t Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, t)
+}
+
+// TraceFromContext returns the Trace carried by ctx, if any.
+func TraceFromContext(ctx context.Context) (Trace, bool) {
+	t, ok := ctx.Value(traceKey{}).(Trace)
+	return t, ok
+}
+
+// InjectTrace will store the trace and span ids of the Trace carried by
+// ctx in the headers of p, or in its data when it has none, under
+// TraceIDKey and SpanIDKey, so that the trace follows the payload
+// across asynchronous bus hops.  It does nothing when ctx carries no
+// Trace or p has neither headers nor data.
+//
+// The handlers of a payload carrying a trace are invoked with a child
+// span, named after the payload type, of the publisher's span: a
+// ContextHandler finds it with TraceFromContext.
+func InjectTrace(ctx context.Context, p Payload) {
+	t, ok := TraceFromContext(ctx)
+	data := headers(p)
+	if !ok || data == nil {
+		return
+	}
+	data[TraceIDKey] = t.TraceID
+	data[SpanIDKey] = t.SpanID
+}
+
+// ExtractTrace will return a context carrying the publisher's span
+// stored in p by InjectTrace, or context.Background() when p carries
+// no trace.
+func ExtractTrace(p Payload) context.Context {
+	ctx := context.Background()
+	if t, ok := payloadTrace(p); ok {
+		ctx = ContextWithTrace(ctx, t)
+	}
+	return ctx
+}
+
+// payloadTrace returns the publisher's span stored in p.
+func payloadTrace(p Payload) (Trace, bool) {
+	data := headers(p)
+	traceID, _ := data[TraceIDKey].(string)
+	spanID, _ := data[SpanIDKey].(string)
+	if traceID == "" || spanID == "" {
+		return Trace{}, false
+	}
+	return Trace{TraceID: traceID, SpanID: spanID}, true
+}
+
+// child returns a new span with the given name whose parent is t.
+func (t Trace) child(name string) Trace {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return Trace{t.TraceID, hex.EncodeToString(id), t.SpanID, name}
+}
+
+// handlerContext returns the context to invoke a handler of p with: a
+// child of the publisher's span when p carries a trace, or else ctx.
+func handlerContext(ctx context.Context, p Payload) context.Context {
+	if t, ok := payloadTrace(p); ok {
+		return ContextWithTrace(ctx, t.child(p.Type()))
+	}
+	return ctx
+}
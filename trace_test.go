@@ -0,0 +1,51 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pajato/event"
+)
+
+func TestTrace(t *testing.T) {
+	b := New()
+	defer b.Close()
+	name := "testEventTrace"
+	var spans []Trace
+	b.AddContextHandlers(name, func(ctx context.Context, p Payload) error {
+		if tr, ok := TraceFromContext(ctx); ok {
+			spans = append(spans, tr)
+		}
+		return nil
+	}, func(ctx context.Context, p Payload) error {
+		if tr, ok := TraceFromContext(ctx); ok {
+			spans = append(spans, tr)
+		}
+		return nil
+	})
+	parent := Trace{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7"}
+	e := event.New(name)
+	InjectTrace(ContextWithTrace(context.Background(), parent), e)
+	if tr, ok := TraceFromContext(ExtractTrace(e)); !ok || tr.TraceID != parent.TraceID || tr.SpanID != parent.SpanID {
+		t.Errorf("The extracted span should be the injected one, but is: %+v.", tr)
+	}
+	b.PostAndWait(e)
+	if len(spans) != 2 {
+		t.Fatalf("Both handlers should see a span, but %v did.", len(spans))
+	}
+	for _, s := range spans {
+		if s.TraceID != parent.TraceID || s.ParentID != parent.SpanID || s.Name != name {
+			t.Errorf("The handler span should be a child named after the type, but is: %+v.", s)
+		}
+	}
+	if spans[0].SpanID == spans[1].SpanID || spans[0].SpanID == parent.SpanID {
+		t.Errorf("Every handler should get a span of its own, but the ids are: %v and %v.", spans[0].SpanID, spans[1].SpanID)
+	}
+	if _, ok := TraceFromContext(ExtractTrace(event.New(name))); ok {
+		t.Error("A payload without a trace should extract no span.")
+	}
+}
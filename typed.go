@@ -0,0 +1,77 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import (
+	"fmt"
+	"sync"
+)
+
+// AddTypedHandler will register fn for the payload type reported by
+// sample.Type(), wrapping it so that fn receives each payload as a T
+// and need not type assert it.  The registration shares the string
+// keyed handler map with AddHandlers, so typed and untyped handlers
+// for the same type are delivered the same payloads.  A payload of the
+// right type string that is not a T is not passed to fn; its delivery
+// fails with an error instead.
+func AddTypedHandler[T Payload](b *Bus, sample T, fn func(T) error) (Subscription, error) {
+	typ := sample.Type()
+	return b.AddHandlers(typ, func(p Payload) error {
+		t, ok := p.(T)
+		if !ok {
+			message := fmt.Sprintf("Type error: payload with type: %v is a %T, not a %T.", typ, p, t)
+			return &busError{b.clock.Now(), message, CodeTypeMismatch, nil}
+		}
+		return fn(t)
+	})
+}
+
+// SubscribeTyped will register a channel for a given payload type, as
+// Subscribe does, and forward each payload it receives to out as the T
+// returned by convert, so that a reader loop receives its own element
+// type.  A payload for which convert returns false is skipped.  The
+// returned function ends the subscription: it removes the channel and
+// stops the forwarding goroutine, which also stops when the bus
+// closes.  Once it returns nothing more is sent to out, which is left
+// open for its owner to close.  Calling it more than once is harmless.
+func SubscribeTyped[T any](b *Bus, typ string, out chan<- T, convert func(Payload) (T, bool)) func() {
+	in, cancel := b.Subscribe(typ)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case p, ok := <-in:
+				if !ok {
+					return
+				}
+				t, ok := convert(p)
+				if !ok {
+					continue
+				}
+				select {
+				case out <- t:
+				case <-stop:
+					return
+				case <-b.quit:
+					return
+				}
+			case <-stop:
+				return
+			case <-b.quit:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			cancel()
+			<-done
+		})
+	}
+}
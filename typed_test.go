@@ -0,0 +1,68 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"testing"
+
+	"github.com/pajato/event"
+)
+
+type login struct {
+	user string
+}
+
+func (l login) Type() string                 { return "user.login" }
+func (l login) Data() map[string]interface{} { return map[string]interface{}{"user": l.user} }
+
+func TestAddTypedHandler(t *testing.T) {
+	b := New()
+	var user string
+	untyped := 0
+	AddTypedHandler(b, login{}, func(l login) error {
+		user = l.user
+		return nil
+	})
+	b.AddHandlers("user.login", func(p Payload) error {
+		untyped++
+		return nil
+	})
+	if err := b.PostAndWait(login{"pat"}); err != nil {
+		t.Errorf("The post failed with message: %v.\n", err)
+	}
+	if user != "pat" {
+		t.Errorf("The typed handler should have seen user pat, but saw: %q.", user)
+	}
+	if untyped != 1 {
+		t.Errorf("The untyped handler should have run once, but ran: %v.", untyped)
+	}
+	if err := b.PostAndWait(event.New("user.login")); err == nil {
+		t.Error("Delivering a payload of the wrong Go type did not fail as expected.")
+	}
+}
+
+func TestSubscribeTyped(t *testing.T) {
+	b := New()
+	defer b.Close()
+	out := make(chan login, 4)
+	unsubscribe := SubscribeTyped(b, "user.login", out, func(p Payload) (login, bool) {
+		l, ok := p.(login)
+		return l, ok
+	})
+	b.PostAndWait(event.New("user.login"))
+	b.PostAndWait(login{"pat"})
+	if l := <-out; l.user != "pat" {
+		t.Errorf("The typed channel should receive user pat, but received: %q.", l.user)
+	}
+	unsubscribe()
+	unsubscribe()
+	b.PostAndWait(login{"sam"})
+	if n := len(out); n != 0 {
+		t.Errorf("Nothing should be forwarded after unsubscribing, but %v payloads were.", n)
+	}
+	if n := b.ChannelCount("user.login"); n != 0 {
+		t.Errorf("Unsubscribing should remove the channel, but %v remain.", n)
+	}
+}
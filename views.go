@@ -0,0 +1,82 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by the GNU GPL v3 license.  See the LICENSE
+// file.
+
+package bus
+
+import "context"
+
+// A Producer is a view of a bus that can only post payloads, for the
+// modules that publish but should not subscribe to, reconfigure or
+// close the shared bus.
+type Producer interface {
+	Post(p Payload) error
+	PostAndWait(p Payload) error
+	PostBatch(ps ...Payload) error
+}
+
+// A Consumer is a view of a bus that can only subscribe to payloads,
+// for the modules that listen but should not post to, reconfigure or
+// close the shared bus.
+type Consumer interface {
+	AddHandlers(typ string, fns ...Handler) (Subscription, error)
+	AddContextHandlers(typ string, fns ...ContextHandler) (Subscription, error)
+	Unsubscribe(s Subscription) int
+	AddChannel(typ string, c chan Payload)
+	AddChannelContext(ctx context.Context, typ string, c chan Payload)
+	RemoveChannel(typ string, c <-chan Payload) bool
+	Subscribe(typ string) (<-chan Payload, func())
+}
+
+// Producer returns a Producer view of the bus.  The view is a thin
+// wrapper sharing all the state of the bus: its posts are the bus's
+// posts and it stops working when the bus is closed.  It does not
+// expose the bus itself, so its holder cannot reach the other methods.
+func (b *Bus) Producer() Producer {
+	return producer{b}
+}
+
+// Consumer returns a Consumer view of the bus.  The view is a thin
+// wrapper sharing all the state of the bus: what it subscribes is
+// subscribed to the bus and it stops receiving when the bus is closed.
+// It does not expose the bus itself, so its holder cannot reach the
+// other methods.
+func (b *Bus) Consumer() Consumer {
+	return consumer{b}
+}
+
+// producer is the Producer view of a bus.
+type producer struct {
+	b *Bus
+}
+
+func (v producer) Post(p Payload) error          { return v.b.Post(p) }
+func (v producer) PostAndWait(p Payload) error   { return v.b.PostAndWait(p) }
+func (v producer) PostBatch(ps ...Payload) error { return v.b.PostBatch(ps...) }
+
+// consumer is the Consumer view of a bus.
+type consumer struct {
+	b *Bus
+}
+
+func (v consumer) AddHandlers(typ string, fns ...Handler) (Subscription, error) {
+	return v.b.AddHandlers(typ, fns...)
+}
+
+func (v consumer) AddContextHandlers(typ string, fns ...ContextHandler) (Subscription, error) {
+	return v.b.AddContextHandlers(typ, fns...)
+}
+
+func (v consumer) Unsubscribe(s Subscription) int { return v.b.Unsubscribe(s) }
+
+func (v consumer) AddChannel(typ string, c chan Payload) { v.b.AddChannel(typ, c) }
+
+func (v consumer) AddChannelContext(ctx context.Context, typ string, c chan Payload) {
+	v.b.AddChannelContext(ctx, typ, c)
+}
+
+func (v consumer) RemoveChannel(typ string, c <-chan Payload) bool {
+	return v.b.RemoveChannel(typ, c)
+}
+
+func (v consumer) Subscribe(typ string) (<-chan Payload, func()) { return v.b.Subscribe(typ) }
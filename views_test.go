@@ -0,0 +1,35 @@
+// Copyright 2015 Pajato Group Inc. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package bus
+
+import (
+	"testing"
+
+	"github.com/pajato/event"
+)
+
+func TestViews(t *testing.T) {
+	b := New()
+	defer b.Close()
+	name := "testEventViews"
+	var producer interface{} = b.Producer()
+	var consumer interface{} = b.Consumer()
+	for _, v := range []interface{}{producer, consumer} {
+		if _, ok := v.(interface{ Close() error }); ok {
+			t.Errorf("A view should not expose Close, but %T does.", v)
+		}
+	}
+	count := 0
+	b.Consumer().AddHandlers(name, func(p Payload) error {
+		count++
+		return nil
+	})
+	if err := b.Producer().PostAndWait(event.New(name)); err != nil {
+		t.Errorf("Posting through the producer failed with message: %v.\n", err)
+	}
+	if count != 1 {
+		t.Errorf("The handler registered through the consumer should have run once, but ran: %v.", count)
+	}
+}